@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"openwith/config"
+	"openwith/handler"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// commands are subcommands that run without the service manager.
+// Each one returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"validate": runValidate,
+	"match":    runMatch,
+	"diff":     runDiff,
+	"convert":  runConvert,
+	"schema":   runSchema,
+}
+
+// configPathArg returns the config path given on the command line,
+// or the default config path
+func configPathArg(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	return config.GetConfigPath()
+}
+
+// runValidate checks a config file: openwith validate [path]
+func runValidate(args []string) int {
+	configPath, err := configPathArg(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get config path: %v\n", err)
+		return 1
+	}
+
+	errs := config.ValidateFile(configPath)
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s is invalid:\n", configPath)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  - %v\n", err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", configPath)
+	return 0
+}
+
+// runMatch explains how a URL would be opened: openwith match <url> [path]
+func runMatch(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: openwith match <url> [config path]")
+		return 2
+	}
+
+	configPath, err := configPathArg(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get config path: %v\n", err)
+		return 1
+	}
+
+	appConfig, err := config.LoadConfigFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file: %v\n", err)
+		return 1
+	}
+
+	if err := appConfig.CheckURL(args[0]); err != nil {
+		fmt.Printf("url          : %s\n", args[0])
+		fmt.Printf("rejected     : %v\n", err)
+		return 0
+	}
+
+	h := handler.NewHandler(&sync.RWMutex{}, appConfig)
+	res := h.Resolve(args[0])
+
+	fmt.Printf("url          : %s\n", res.OriginalURL)
+	for _, trace := range res.Trace {
+		fmt.Printf("  [%d] skipped (%s): %s\n", trace.Index, trace.Source, trace.Reason)
+	}
+	if res.PatternIndex >= 0 {
+		matched := appConfig.URLPatterns[res.PatternIndex]
+		pattern := matched.Pattern
+		if pattern == "" {
+			pattern = "(match conditions)"
+		}
+		fmt.Printf("matched      : url_patterns[%d] %s\n", res.PatternIndex, pattern)
+		fmt.Printf("from         : %s url_patterns[%d]\n", matched.Source, matched.SourceIndex)
+	} else {
+		fmt.Printf("matched      : none (%s)\n", res.HandledBy)
+	}
+	if res.HandledBy == handler.HandledByRejected {
+		return 0
+	}
+	fmt.Printf("modified url : %s\n", res.URL)
+	if err := res.CheckArgs(); err != nil {
+		fmt.Printf("rejected     : %v\n", err)
+		return 0
+	}
+	fmt.Printf("command      : %s\n", formatCommandLine(res.Application.Path, res.CommandArgs()))
+	return 0
+}
+
+// runDiff compares two config files: openwith diff <old> <new>
+// Like diff(1) it exits with 1 when the files differ.
+func runDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: openwith diff <old config> <new config>")
+		return 2
+	}
+
+	configs := make([]*config.Config, len(args))
+	for i, path := range args {
+		appConfig, err := config.LoadConfigFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", path, err)
+			return 2
+		}
+		configs[i] = appConfig
+	}
+
+	changes := config.Diff(configs[0], configs[1])
+	if len(changes) == 0 {
+		fmt.Println("No differences")
+		return 0
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return 1
+}
+
+// runConvert rewrites a config file in another format:
+// openwith convert <input> <output>. The formats are detected by extension.
+func runConvert(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: openwith convert <input> <output.json|.yaml|.toml>")
+		return 2
+	}
+	input, output := args[0], args[1]
+
+	// Convert the file as written, so includes and ${VAR} values stay as
+	// they are instead of being merged and expanded
+	original, err := config.ReadConfigFile(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", input, err)
+		return 1
+	}
+
+	data, err := config.EncodeConfig(original, config.FormatOf(output))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to convert %s: %v\n", input, err)
+		return 1
+	}
+
+	// Write next to the output and only move it into place once the
+	// converted file reads back to the same config
+	ext := filepath.Ext(output)
+	tmp, err := os.CreateTemp(filepath.Dir(output), "."+strings.TrimSuffix(filepath.Base(output), ext)+"-*"+ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", output, err)
+		return 1
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", output, err)
+		return 1
+	}
+
+	converted, err := config.ReadConfigFile(tmp.Name())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Converted file does not load: %v\n", err)
+		return 1
+	}
+	originalJSON, _ := config.EncodeConfig(original, config.FormatJSON)
+	convertedJSON, _ := config.EncodeConfig(converted, config.FormatJSON)
+	if !bytes.Equal(originalJSON, convertedJSON) {
+		fmt.Fprintf(os.Stderr, "Converted file differs from %s, %s not written:\n", input, output)
+		for _, change := range config.Diff(original, converted) {
+			fmt.Fprintf(os.Stderr, "  %s\n", change)
+		}
+		return 1
+	}
+
+	if err := os.Rename(tmp.Name(), output); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", output, err)
+		return 1
+	}
+	fmt.Printf("Converted %s -> %s\n", input, output)
+	return 0
+}
+
+// runSchema prints the JSON Schema of config files: openwith schema
+func runSchema(args []string) int {
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate schema: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// formatCommandLine joins a command and its args, quoting args that
+// contain spaces or quotes
+func formatCommandLine(app string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{app}, args...) {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+			arg = strconv.Quote(arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Authentication modes
+const (
+	AuthToken = "token" // Authorization: Bearer <secret>
+	AuthHMAC  = "hmac"  // HMAC-SHA256 of the timestamp and body, keyed with the secret
+)
+
+// DefaultMaxSkew is how far the timestamp of a signed request may be from
+// the server time, in seconds
+const DefaultMaxSkew = 300
+
+// AuthConfig enables authentication of requests with a shared secret.
+// The secret is given inline or read from a key file.
+type AuthConfig struct {
+	Mode       string `json:"mode"`
+	Secret     string `json:"secret,omitempty"`
+	SecretFile string `json:"secret_file,omitempty"`
+	MaxSkew    int    `json:"max_skew_seconds,omitempty"`
+	Key        []byte `json:"-"`
+}
+
+// prepare resolves the secret and checks the settings
+func (a *AuthConfig) prepare() error {
+	switch a.Mode {
+	case AuthToken, AuthHMAC:
+	default:
+		return fmt.Errorf("unknown mode %q", a.Mode)
+	}
+
+	if a.MaxSkew < 0 {
+		return fmt.Errorf("max_skew_seconds must not be negative")
+	}
+	if a.MaxSkew == 0 {
+		a.MaxSkew = DefaultMaxSkew
+	}
+
+	switch {
+	case a.Secret != "" && a.SecretFile != "":
+		return fmt.Errorf("set either secret or secret_file, not both")
+	case a.Secret != "":
+		a.Key = []byte(a.Secret)
+	case a.SecretFile != "":
+		path, err := expandValue(a.SecretFile, nil)
+		if err != nil {
+			return fmt.Errorf("secret_file: %w", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("secret_file: %w", err)
+		}
+		a.SecretFile = path
+		a.Key = bytes.TrimSpace(data)
+	}
+
+	if len(a.Key) == 0 {
+		return fmt.Errorf("secret is empty")
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config without secrets, for logging
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if c.Auth != nil {
+		auth := *c.Auth
+		if auth.Secret != "" {
+			auth.Secret = "********"
+		}
+		auth.Key = nil
+		redacted.Auth = &auth
+	}
+	return &redacted
+}
@@ -1,26 +1,310 @@
 package config
 
 import (
-	"encoding/json"
-	"log"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
-	"sync"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Application is a named launch profile that url_patterns can refer to
+type Application struct {
+	Path string            `json:"path"`
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+	Dir  string            `json:"dir,omitempty"`
+}
+
+// URLRewrite rewrites the whole URL with a regex replacement.
+// Replace may refer to capture groups as $1 or ${name}.
+type URLRewrite struct {
+	Pattern     string         `json:"pattern"`
+	Replace     string         `json:"replace"`
+	CompiledReg *regexp.Regexp `json:"-"`
+}
+
+// Query parameter operations
+const (
+	QueryOpSet     = "set"     // always set the value
+	QueryOpReplace = "replace" // set the value only if the parameter is present
+	QueryOpDefault = "default" // set the value only if the parameter is absent
+	QueryOpDelete  = "delete"  // remove the parameter
+)
+
+// QueryOp is a single query parameter operation.
+// Name may be a glob such as "utm_*" for replace and delete.
+type QueryOp struct {
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Exact bool   `json:"-"` // match Name literally, for url_params entries
+}
+
 type URLPattern struct {
+	// Source and SourceIndex tell which file and entry the pattern came from
+	Source      string `json:"-"`
+	SourceIndex int    `json:"-"`
+
 	Pattern     string            `json:"pattern"`
+	Match       *MatchCondition   `json:"match,omitempty"`
+	Application string            `json:"application,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
 	Args        []string          `json:"args"`
+	Rewrite     *URLRewrite       `json:"rewrite,omitempty"`
 	URLParams   map[string]string `json:"url_params"`
+	Query       []QueryOp         `json:"query,omitempty"`
 	CompiledReg *regexp.Regexp    `json:"-"`
 }
 
+// DefaultPort is used when the config does not specify a port
+const DefaultPort = 44525
+
+// TLSConfig enables HTTPS on the listener
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// What to do with a URL that no url_patterns entry matches
+const (
+	NoMatchDefault = "default" // launch the default application
+	NoMatchReject  = "reject"  // reject the request
+	NoMatchSystem  = "system"  // hand the URL to the system opener
+)
+
+// DefaultRule is used for URLs that no url_patterns entry matches
+type DefaultRule struct {
+	Application string   `json:"application,omitempty"`
+	Profile     string   `json:"profile,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	OnNoMatch   string   `json:"on_no_match,omitempty"`
+}
+
 type Config struct {
-	Application string       `json:"application"`
-	Port        int          `json:"port"`
-	URLPatterns []URLPattern `json:"url_patterns"`
+	Schema            string                 `json:"$schema,omitempty"`
+	Application       string                 `json:"application"`
+	Applications      map[string]Application `json:"applications,omitempty"`
+	Listen            string                 `json:"listen,omitempty"`
+	AllowedClients    []string               `json:"allowed_clients,omitempty"`
+	AllowedClientNets []*net.IPNet           `json:"-"`
+	AllowedOrigins    []string               `json:"allowed_origins,omitempty"`
+	AllowedSchemes    []string               `json:"allowed_schemes,omitempty"`
+	MaxURLLength      int                    `json:"max_url_length,omitempty"`
+	AllowGet          bool                   `json:"allow_get,omitempty"` // serve GET /open for bookmarklets, requires auth
+	Port              int                    `json:"port,omitempty"`
+	TLS               *TLSConfig             `json:"tls,omitempty"`
+	Auth              *AuthConfig            `json:"auth,omitempty"`
+	Default           *DefaultRule           `json:"default,omitempty"`
+	Include           []string               `json:"include,omitempty"`
+	NoConfigD         bool                   `json:"no_config_d,omitempty"`
+	URLPatterns       []URLPattern           `json:"url_patterns"`
+
+	snapshot []byte // merged config before expansion, saved as last-good copy
+}
+
+// DefaultListen is the bind address used when the config does not specify
+// one. Only local clients can connect unless listen is set explicitly.
+const DefaultListen = "127.0.0.1"
+
+// ListenAddress returns the host:port the server listens on
+func (c *Config) ListenAddress() string {
+	host := c.Listen
+	if host == "" {
+		host = DefaultListen
+	}
+	port := c.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// ClientAllowed reports whether a client IP may use the server.
+// Every client is allowed when allowed_clients is empty.
+func (c *Config) ClientAllowed(ip net.IP) bool {
+	if len(c.AllowedClientNets) == 0 {
+		return true
+	}
+	for _, network := range c.AllowedClientNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedClients parses allowed_clients entries, which are CIDRs or
+// single IP addresses
+func (c *Config) parseAllowedClients() error {
+	c.AllowedClientNets = nil
+	for i, entry := range c.AllowedClients {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("allowed_clients[%d]: invalid IP address %q", i, entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			c.AllowedClientNets = append(c.AllowedClientNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("allowed_clients[%d]: %w", i, err)
+		}
+		c.AllowedClientNets = append(c.AllowedClientNets, network)
+	}
+	return nil
+}
+
+// ApplicationFor returns the application to launch for the given pattern.
+// A profile takes precedence over a plain application path, and a nil
+// pattern or one without either falls back to DefaultApplication.
+func (c *Config) ApplicationFor(pattern *URLPattern) Application {
+	if pattern != nil {
+		if pattern.Profile != "" {
+			if app, ok := c.Applications[pattern.Profile]; ok {
+				return app
+			}
+		}
+		if pattern.Application != "" {
+			return Application{Path: pattern.Application}
+		}
+	}
+	return c.DefaultApplication()
+}
+
+// DefaultApplication returns the application of the default section,
+// or Config.Application when the section does not specify one
+func (c *Config) DefaultApplication() Application {
+	if c.Default != nil {
+		if c.Default.Profile != "" {
+			if app, ok := c.Applications[c.Default.Profile]; ok {
+				return app
+			}
+		}
+		if c.Default.Application != "" {
+			return Application{Path: c.Default.Application}
+		}
+	}
+	return Application{Path: c.Application}
+}
+
+// NoMatchPolicy returns what to do with URLs that no pattern matches
+func (c *Config) NoMatchPolicy() string {
+	if c.Default == nil || c.Default.OnNoMatch == "" {
+		return NoMatchDefault
+	}
+	return c.Default.OnNoMatch
+}
+
+// prepareDefault expands and checks the default section
+func (c *Config) prepareDefault() error {
+	var err error
+	switch c.Default.OnNoMatch {
+	case "", NoMatchDefault, NoMatchReject, NoMatchSystem:
+	default:
+		return fmt.Errorf("unknown on_no_match %q", c.Default.OnNoMatch)
+	}
+	if c.Default.Application, err = expandValue(c.Default.Application, nil); err != nil {
+		return fmt.Errorf("application: %w", err)
+	}
+	keep := argsKeep(nil)
+	for i := range c.Default.Args {
+		if c.Default.Args[i], err = expandValue(c.Default.Args[i], keep); err != nil {
+			return fmt.Errorf("args[%d]: %w", i, err)
+		}
+	}
+	if c.Default.Profile != "" {
+		if _, ok := c.Applications[c.Default.Profile]; !ok {
+			return fmt.Errorf("unknown profile %q", c.Default.Profile)
+		}
+	}
+	return nil
+}
+
+// location returns the file and index of the pattern for error messages
+func (p *URLPattern) location() string {
+	if p.Source == "" {
+		return fmt.Sprintf("url_patterns[%d]", p.SourceIndex)
+	}
+	return fmt.Sprintf("%s: url_patterns[%d]", p.Source, p.SourceIndex)
+}
+
+// MatchURL reports whether the pattern matches the URL and returns the
+// regex submatches, which are empty when the pattern has no regex.
+func (p *URLPattern) MatchURL(rawURL string, parsedURL *url.URL) (bool, []string) {
+	matches, reason := p.ExplainMatch(rawURL, parsedURL)
+	return reason == "", matches
+}
+
+// ExplainMatch is like MatchURL but returns the reason the pattern did not
+// match instead of a bool. The reason is empty when the pattern matches.
+func (p *URLPattern) ExplainMatch(rawURL string, parsedURL *url.URL) ([]string, string) {
+	if p.CompiledReg == nil && p.Match == nil {
+		return nil, "pattern is not compiled"
+	}
+
+	matches := []string{}
+	if p.CompiledReg != nil {
+		matches = p.CompiledReg.FindStringSubmatch(rawURL)
+		if matches == nil {
+			return nil, fmt.Sprintf("regex %q did not match", p.Pattern)
+		}
+	}
+
+	if p.Match != nil {
+		if reason := p.Match.Explain(parsedURL); reason != "" {
+			return nil, reason
+		}
+	}
+
+	return matches, ""
+}
+
+// QueryOps returns the query operations of the pattern.
+// Entries in url_params are treated as replace operations and come first.
+// Their names are matched literally, so keys such as "filter[type]" are
+// not read as globs.
+func (p *URLPattern) QueryOps() []QueryOp {
+	keys := make([]string, 0, len(p.URLParams))
+	for key := range p.URLParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ops := make([]QueryOp, 0, len(keys)+len(p.Query))
+	for _, key := range keys {
+		ops = append(ops, QueryOp{Op: QueryOpReplace, Name: key, Value: p.URLParams[key], Exact: true})
+	}
+	return append(ops, p.Query...)
+}
+
+func validateQueryOp(op QueryOp) error {
+	if op.Name == "" {
+		return fmt.Errorf("query operation %q has no name", op.Op)
+	}
+	if _, err := path.Match(op.Name, ""); err != nil {
+		return fmt.Errorf("invalid query name %q: %w", op.Name, err)
+	}
+	switch op.Op {
+	case QueryOpReplace, QueryOpDelete:
+		return nil
+	case QueryOpSet, QueryOpDefault:
+		if strings.ContainsAny(op.Name, "*?[") {
+			return fmt.Errorf("query operation %q does not accept a glob name: %q", op.Op, op.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown query operation %q", op.Op)
 }
 
 func LoadConfig() (*Config, error) {
@@ -28,83 +312,238 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return LoadConfigFile(configPath)
+}
+
+// LoadConfigFile loads and validates the config file at the given path
+func LoadConfigFile(configPath string) (*Config, error) {
+	config, _, err := loadConfigData(configPath)
+	return config, err
+}
 
-	file, err := os.Open(configPath)
+// loadConfigData is LoadConfigFile that also returns the checksum of the
+// config file and every file it includes
+func loadConfigData(configPath string) (*Config, string, error) {
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	defer file.Close()
 
 	var config Config
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
+	if err := decodeConfigData(data, FormatOf(configPath), &config); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", configPath, err)
+	}
+	config.setSource(configPath)
+
+	files, err := config.mergeIncludes(configPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	for i := range config.URLPatterns {
-		pattern := &config.URLPatterns[i]
-		pattern.CompiledReg, err = regexp.Compile(pattern.Pattern)
-		if err != nil {
-			return nil, err
+	// The last-good copy is taken before prepare expands ${VAR} values,
+	// so it neither stores their values nor expands them twice
+	snapshot := config
+	snapshot.Include = nil
+	snapshot.NoConfigD = true
+	if config.snapshot, err = EncodeConfig(&snapshot, FormatOf(configPath)); err != nil {
+		return nil, "", err
+	}
+
+	if err := config.prepare(); err != nil {
+		return nil, "", err
+	}
+
+	return &config, checksumFiles(append([]fileData{{configPath, data}}, files...)), nil
+}
+
+// prepare compiles the regexes of the config and checks its references.
+// It returns the first problem found.
+func (c *Config) prepare() error {
+	if errs := c.check(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// check does the work of prepare and returns every problem found. The
+// sections are checked independently, so one mistake does not hide the
+// others.
+func (c *Config) check() []error {
+	var errs []error
+	var err error
+	fail := func(err error) {
+		errs = append(errs, err)
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		fail(fmt.Errorf("port %d is out of range (1-65535)", c.Port))
+	}
+
+	if err := c.parseAllowedClients(); err != nil {
+		fail(err)
+	}
+
+	if err := c.prepareAllowedOrigins(); err != nil {
+		fail(err)
+	}
+
+	if err := c.prepareURLChecks(); err != nil {
+		fail(err)
+	}
+
+	if c.Auth != nil {
+		if err := c.Auth.prepare(); err != nil {
+			fail(fmt.Errorf("auth: %w", err))
+		}
+	}
+	// Any web page can make the browser send a GET request, so only a
+	// secret can tell the user's own bookmarklets apart from drive-by pages
+	if c.AllowGet && c.Auth == nil {
+		fail(fmt.Errorf("allow_get requires auth"))
+	}
+
+	if c.TLS != nil && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		fail(fmt.Errorf("tls requires both cert_file and key_file"))
+	}
+
+	if c.Application, err = expandValue(c.Application, nil); err != nil {
+		fail(fmt.Errorf("application: %w", err))
+	}
+	if c.TLS != nil {
+		if c.TLS.CertFile, err = expandValue(c.TLS.CertFile, nil); err != nil {
+			fail(fmt.Errorf("tls.cert_file: %w", err))
+		}
+		if c.TLS.KeyFile, err = expandValue(c.TLS.KeyFile, nil); err != nil {
+			fail(fmt.Errorf("tls.key_file: %w", err))
 		}
 	}
 
-	return &config, nil
+	// Sorted so that problems are reported in a stable order
+	names := make([]string, 0, len(c.Applications))
+	for name := range c.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		app := c.Applications[name]
+		if err := expandApplication(&app); err != nil {
+			fail(fmt.Errorf("applications[%q].%w", name, err))
+		}
+		if app.Path == "" {
+			fail(fmt.Errorf("application %q has no path", name))
+		}
+		c.Applications[name] = app
+	}
+
+	if c.Default != nil {
+		if err := c.prepareDefault(); err != nil {
+			fail(fmt.Errorf("default: %w", err))
+		}
+	}
+
+	for i := range c.URLPatterns {
+		pattern := &c.URLPatterns[i]
+		if pattern.Source == "" {
+			pattern.SourceIndex = i
+		}
+		// A pattern with only match conditions has no regex
+		if pattern.Pattern != "" || pattern.Match == nil {
+			pattern.CompiledReg, err = regexp.Compile(pattern.Pattern)
+			if err != nil {
+				fail(fmt.Errorf("%s.pattern: %w", pattern.location(), err))
+			}
+		}
+		if err := expandPattern(pattern); err != nil {
+			fail(fmt.Errorf("%s.%w", pattern.location(), err))
+		}
+		if pattern.Match != nil && pattern.Match.Host != "" {
+			if _, err := path.Match(pattern.Match.Host, ""); err != nil {
+				fail(fmt.Errorf("%s.match.host: %w", pattern.location(), err))
+			}
+		}
+		if pattern.Rewrite != nil {
+			pattern.Rewrite.CompiledReg, err = regexp.Compile(pattern.Rewrite.Pattern)
+			if err != nil {
+				fail(fmt.Errorf("%s.rewrite: %w", pattern.location(), err))
+			}
+		}
+		for j, op := range pattern.Query {
+			if err := validateQueryOp(op); err != nil {
+				fail(fmt.Errorf("%s.query[%d]: %w", pattern.location(), j, err))
+			}
+		}
+		if pattern.Profile != "" {
+			if _, ok := c.Applications[pattern.Profile]; !ok {
+				fail(fmt.Errorf("%s: unknown profile %q", pattern.location(), pattern.Profile))
+			}
+		}
+	}
+
+	return errs
+}
+
+// ConfigPathEnv is the environment variable that overrides the config path
+const ConfigPathEnv = "OPENWITH_CONFIG"
+
+var configPathOverride string
+
+// SetConfigPath overrides the config file location, e.g. from the --config flag
+func SetConfigPath(path string) {
+	configPathOverride = path
 }
 
+// GetConfigPath returns the config file to use. The search order is
+//
+//  1. the path given to SetConfigPath (--config flag)
+//  2. $OPENWITH_CONFIG
+//  3. $XDG_CONFIG_HOME/openwith/config.{json,yaml,yml,toml} (~/.config when unset)
+//  4. config.{json,yaml,yml,toml} next to the executable
+//
+// Candidates 3 and 4 are only used if the file exists. When none of them
+// exists config.json next to the executable is returned.
 func GetConfigPath() (string, error) {
+	if configPathOverride != "" {
+		return configPathOverride, nil
+	}
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path, nil
+	}
+
+	var dirs []string
+	if dir := xdgConfigHome(); dir != "" {
+		dirs = append(dirs, filepath.Join(dir, "openwith"))
+	}
+
 	// Get the directory of the current executable
 	exePath, err := os.Executable()
 	if err != nil {
 		return "", err
 	}
 	exeDir := filepath.Dir(exePath)
-	return filepath.Join(exeDir, "config.json"), nil
-}
+	dirs = append(dirs, exeDir)
 
-// ConfigUpdateCallback is the function type for config update callbacks
-type ConfigUpdateCallback func(*Config)
+	var candidates []string
+	for _, dir := range dirs {
+		for _, name := range configFileNames {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
 
-// WatchConfigFile monitors config file changes and calls the callback when updated
-func WatchConfigFile(configMutex *sync.RWMutex, appConfig **Config, callback ConfigUpdateCallback) {
-	configPath, err := GetConfigPath()
-	if err != nil {
-		log.Printf("Failed to get config path: %v", err)
-		return
-	}
-
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	var lastModTime time.Time
-	if stat, err := os.Stat(configPath); err == nil {
-		lastModTime = stat.ModTime()
-	}
-
-	for {
-		select {
-		case <-ticker.C:
-			if stat, err := os.Stat(configPath); err == nil {
-				if stat.ModTime().After(lastModTime) {
-					log.Println("Config file changed, reloading...")
-					if newConfig, err := LoadConfig(); err == nil {
-						configMutex.Lock()
-						*appConfig = newConfig
-						configMutex.Unlock()
-						
-						// Call the callback with the new config
-						if callback != nil {
-							callback(newConfig)
-						}
-						
-						log.Println("Config reloaded successfully")
-					} else {
-						log.Printf("Failed to reload config: %v", err)
-					}
-					lastModTime = stat.ModTime()
-				}
-			}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
 		}
 	}
+	return filepath.Join(exeDir, "config.json"), nil
+}
+
+// xdgConfigHome returns $XDG_CONFIG_HOME, or ~/.config when it is unset
+func xdgConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config")
+	}
+	return ""
 }
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Diff returns a human-readable list of the differences between two configs.
+// url_patterns are identified by their pattern (or match conditions), so
+// a moved rule is reported as reordered rather than removed and added.
+func Diff(oldConfig, newConfig *Config) []string {
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	if oldConfig.Application != newConfig.Application {
+		add("application: %q -> %q", oldConfig.Application, newConfig.Application)
+	}
+	if oldConfig.ListenAddress() != newConfig.ListenAddress() {
+		add("listen: %s -> %s", oldConfig.ListenAddress(), newConfig.ListenAddress())
+	}
+	if !reflect.DeepEqual(oldConfig.AllowedClients, newConfig.AllowedClients) {
+		add("allowed_clients: %s -> %s", toJSON(oldConfig.AllowedClients), toJSON(newConfig.AllowedClients))
+	}
+	if !reflect.DeepEqual(oldConfig.AllowedOrigins, newConfig.AllowedOrigins) {
+		add("allowed_origins: %s -> %s", toJSON(oldConfig.AllowedOrigins), toJSON(newConfig.AllowedOrigins))
+	}
+	if !reflect.DeepEqual(oldConfig.Schemes(), newConfig.Schemes()) {
+		add("allowed_schemes: %s -> %s", toJSON(oldConfig.Schemes()), toJSON(newConfig.Schemes()))
+	}
+	if oldConfig.MaxURLLen() != newConfig.MaxURLLen() {
+		add("max_url_length: %d -> %d", oldConfig.MaxURLLen(), newConfig.MaxURLLen())
+	}
+	if oldConfig.AllowGet != newConfig.AllowGet {
+		add("allow_get: %t -> %t", oldConfig.AllowGet, newConfig.AllowGet)
+	}
+	if !reflect.DeepEqual(oldConfig.Auth, newConfig.Auth) {
+		// Secrets are not logged
+		oldAuth, newAuth := toJSON(oldConfig.Redacted().Auth), toJSON(newConfig.Redacted().Auth)
+		if oldAuth == newAuth {
+			add("auth: secret changed")
+		} else {
+			add("auth: %s -> %s", oldAuth, newAuth)
+		}
+	}
+	if !reflect.DeepEqual(oldConfig.Default, newConfig.Default) {
+		add("default: %s -> %s", toJSON(oldConfig.Default), toJSON(newConfig.Default))
+	}
+	if !reflect.DeepEqual(oldConfig.TLS, newConfig.TLS) {
+		add("tls: %s -> %s", toJSON(oldConfig.TLS), toJSON(newConfig.TLS))
+	}
+
+	for _, name := range unionKeys(oldConfig.Applications, newConfig.Applications) {
+		oldApp, inOld := oldConfig.Applications[name]
+		newApp, inNew := newConfig.Applications[name]
+		switch {
+		case !inOld:
+			add("applications[%q] added: %s", name, toJSON(newApp))
+		case !inNew:
+			add("applications[%q] removed", name)
+		case !reflect.DeepEqual(oldApp, newApp):
+			add("applications[%q] changed: %s -> %s", name, toJSON(oldApp), toJSON(newApp))
+		}
+	}
+
+	oldKeys := patternKeys(oldConfig.URLPatterns)
+	newKeys := patternKeys(newConfig.URLPatterns)
+	oldIndex := keyIndex(oldKeys)
+	newIndex := keyIndex(newKeys)
+
+	for i := range oldConfig.URLPatterns {
+		if _, ok := newIndex[oldKeys[i]]; !ok {
+			add("url_patterns[%d] removed: %s", i, oldKeys[i])
+		}
+	}
+
+	moved := movedKeys(oldKeys, newKeys, oldIndex, newIndex)
+	for i := range newConfig.URLPatterns {
+		j, ok := oldIndex[newKeys[i]]
+		if !ok {
+			add("url_patterns[%d] added: %s", i, newKeys[i])
+			continue
+		}
+		if moved[newKeys[i]] {
+			add("url_patterns[%d] reordered: %s (was %d)", i, newKeys[i], j)
+		}
+		for _, change := range diffPattern(&oldConfig.URLPatterns[j], &newConfig.URLPatterns[i]) {
+			add("url_patterns[%d] %s", i, change)
+		}
+	}
+
+	return lines
+}
+
+// diffPattern compares the settings of two patterns with the same key
+func diffPattern(oldPattern, newPattern *URLPattern) []string {
+	var changes []string
+	compare := func(name string, oldValue, newValue any) {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, toJSON(oldValue), toJSON(newValue)))
+		}
+	}
+
+	compare("application", oldPattern.Application, newPattern.Application)
+	compare("profile", oldPattern.Profile, newPattern.Profile)
+	compare("args", oldPattern.Args, newPattern.Args)
+	compare("url_params", oldPattern.URLParams, newPattern.URLParams)
+	compare("query", oldPattern.Query, newPattern.Query)
+	compare("rewrite", rewriteValue(oldPattern.Rewrite), rewriteValue(newPattern.Rewrite))
+	return changes
+}
+
+// patternKeys returns an identifying key for every pattern. Duplicates
+// are numbered so that each key is unique.
+func patternKeys(patterns []URLPattern) []string {
+	keys := make([]string, len(patterns))
+	seen := map[string]int{}
+	for i := range patterns {
+		key := describePattern(&patterns[i])
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s #%d", key, seen[key])
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func keyIndex(keys []string) map[string]int {
+	index := map[string]int{}
+	for i, key := range keys {
+		index[key] = i
+	}
+	return index
+}
+
+// movedKeys returns the patterns whose order relative to the other
+// patterns changed. Patterns on the longest common subsequence of both
+// orders are considered in place, so inserting or removing a rule does
+// not report every rule after it as moved.
+func movedKeys(oldKeys, newKeys []string, oldIndex, newIndex map[string]int) map[string]bool {
+	var oldCommon, newCommon []string
+	for _, key := range oldKeys {
+		if _, ok := newIndex[key]; ok {
+			oldCommon = append(oldCommon, key)
+		}
+	}
+	for _, key := range newKeys {
+		if _, ok := oldIndex[key]; ok {
+			newCommon = append(newCommon, key)
+		}
+	}
+
+	// lengths[i][j] is the LCS length of oldCommon[i:] and newCommon[j:]
+	n := len(oldCommon)
+	lengths := make([][]int, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int, n+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			if oldCommon[i] == newCommon[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	inPlace := map[string]bool{}
+	for i, j := 0, 0; i < n && j < n; {
+		switch {
+		case oldCommon[i] == newCommon[j]:
+			inPlace[oldCommon[i]] = true
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	moved := map[string]bool{}
+	for _, key := range newCommon {
+		if !inPlace[key] {
+			moved[key] = true
+		}
+	}
+	return moved
+}
+
+// describePattern returns the regex and match conditions of a pattern
+func describePattern(pattern *URLPattern) string {
+	description := fmt.Sprintf("%q", pattern.Pattern)
+	if pattern.Match != nil {
+		description += " match " + toJSON(pattern.Match)
+	}
+	return description
+}
+
+func rewriteValue(rewrite *URLRewrite) any {
+	if rewrite == nil {
+		return nil
+	}
+	return [2]string{rewrite.Pattern, rewrite.Replace}
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
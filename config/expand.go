@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// expandValue expands ${VAR}, ${VAR:-default} and a leading ~ in a config
+// value. Undefined variables without a default are an error.
+//
+// keep is used for args: names it accepts are args template variables
+// (see handler) and are left for the handler to expand, as is $$.
+// Without keep, $$ becomes a literal dollar sign.
+func expandValue(value string, keep func(name string) bool) (string, error) {
+	if value == "~" || strings.HasPrefix(value, "~/") || strings.HasPrefix(value, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		value = filepath.Join(home, value[1:])
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 >= len(value) {
+			sb.WriteByte(value[i])
+			continue
+		}
+
+		if value[i+1] == '$' {
+			if keep != nil {
+				sb.WriteString("$$")
+			} else {
+				sb.WriteByte('$')
+			}
+			i++
+			continue
+		}
+
+		if value[i+1] != '{' {
+			sb.WriteByte(value[i])
+			continue
+		}
+		closing := strings.IndexByte(value[i+2:], '}')
+		if closing < 0 {
+			sb.WriteByte(value[i])
+			continue
+		}
+		expr := value[i+2 : i+2+closing]
+		end := i + 2 + closing + 1
+
+		name, fallback, hasDefault := strings.Cut(expr, ":-")
+		if keep != nil && !hasDefault && keep(name) {
+			sb.WriteString(value[i:end])
+		} else if env, ok := os.LookupEnv(name); ok && env != "" {
+			sb.WriteString(env)
+		} else if hasDefault {
+			sb.WriteString(fallback)
+		} else if ok {
+			// Defined but empty
+		} else {
+			return "", fmt.Errorf("undefined variable ${%s}", name)
+		}
+		i = end - 1
+	}
+	return sb.String(), nil
+}
+
+// argsTemplateNames are the args template variables expanded by the handler
+var argsTemplateNames = map[string]bool{
+	"url": true, "scheme": true, "host": true, "port": true, "path": true, "fragment": true,
+}
+
+// argsKeep returns the names to leave in args for the given pattern regex
+func argsKeep(reg *regexp.Regexp) func(string) bool {
+	return func(name string) bool {
+		if argsTemplateNames[name] || strings.HasPrefix(name, "query.") {
+			return true
+		}
+		if name != "" && strings.Trim(name, "0123456789") == "" {
+			return true
+		}
+		return reg != nil && reg.SubexpIndex(name) >= 0
+	}
+}
+
+// expandApplication expands the variables in an application profile
+func expandApplication(app *Application) error {
+	var err error
+	if app.Path, err = expandValue(app.Path, nil); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	if app.Dir, err = expandValue(app.Dir, nil); err != nil {
+		return fmt.Errorf("dir: %w", err)
+	}
+	for i := range app.Args {
+		if app.Args[i], err = expandValue(app.Args[i], nil); err != nil {
+			return fmt.Errorf("args[%d]: %w", i, err)
+		}
+	}
+	for key, value := range app.Env {
+		if app.Env[key], err = expandValue(value, nil); err != nil {
+			return fmt.Errorf("env[%q]: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// expandPattern expands the variables in the application and args of a
+// pattern. CompiledReg must be set so that capture names are kept.
+func expandPattern(pattern *URLPattern) error {
+	var err error
+	if pattern.Application, err = expandValue(pattern.Application, nil); err != nil {
+		return fmt.Errorf("application: %w", err)
+	}
+	keep := argsKeep(pattern.CompiledReg)
+	for i := range pattern.Args {
+		if pattern.Args[i], err = expandValue(pattern.Args[i], keep); err != nil {
+			return fmt.Errorf("args[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the file format of a config file
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// configFileNames are the file names searched for in a config directory
+var configFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FormatOf detects the format of a config file from its extension.
+// Anything that is not YAML or TOML is read as JSON.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// toJSONData converts YAML and TOML content to JSON so that every format
+// is decoded with the json tags of Config
+func toJSONData(data []byte, format Format) ([]byte, error) {
+	var value any
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	if value == nil {
+		value = map[string]any{}
+	}
+	return json.Marshal(value)
+}
+
+// decodeConfigData decodes config file content of the given format
+func decodeConfigData(data []byte, format Format, config *Config) error {
+	jsonData, err := toJSONData(data, format)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(jsonData, config); err != nil {
+		if format == FormatJSON {
+			return describeJSONError(data, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadConfigFile decodes a config file as it is written, without merging
+// included files or expanding values, for tools that rewrite the file
+func ReadConfigFile(configPath string) (*Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := decodeConfigData(data, FormatOf(configPath), &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	return &config, nil
+}
+
+// EncodeConfig writes the config in the given format
+func EncodeConfig(config *Config, format Format) ([]byte, error) {
+	jsonData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		return append(jsonData, '\n'), nil
+	case FormatYAML:
+		// Decode through yaml.Node to keep the field order of Config
+		var node yaml.Node
+		if err := yaml.Unmarshal(jsonData, &node); err != nil {
+			return nil, err
+		}
+		cleanYAMLNode(&node)
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		encoder.Close()
+		return buf.Bytes(), nil
+	case FormatTOML:
+		var value map[string]any
+		if err := json.Unmarshal(jsonData, &value); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(cleanValue(value)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown config format %q", format)
+}
+
+// cleanYAMLNode switches JSON flow style to block style and drops null values
+func cleanYAMLNode(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.MappingNode {
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Tag == "!!null" {
+				continue
+			}
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+		node.Content = content
+	}
+	for _, child := range node.Content {
+		cleanYAMLNode(child)
+	}
+}
+
+// cleanValue drops null values, which TOML cannot represent, and turns
+// whole numbers back into integers
+func cleanValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = cleanValue(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = cleanValue(item)
+		}
+		return v
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fullConfig sets fields of every kind: nested structs, maps, lists,
+// booleans, integers and values that must not be expanded
+const fullConfig = `{
+  "application": "${HOME}/bin/browser",
+  "applications": {
+    "work": {"path": "/usr/bin/chrome", "args": ["--profile-directory=Work"], "env": {"LANG": "C"}, "dir": "~"}
+  },
+  "listen": "0.0.0.0",
+  "allowed_clients": ["127.0.0.1", "10.0.0.0/8"],
+  "allowed_origins": ["chrome-extension://abc"],
+  "allowed_schemes": ["http", "https", "mailto"],
+  "max_url_length": 4096,
+  "port": 44600,
+  "tls": {"cert_file": "cert.pem", "key_file": "key.pem"},
+  "default": {"profile": "work", "on_no_match": "system"},
+  "include": ["rules/*.json"],
+  "no_config_d": true,
+  "url_patterns": [
+    {"pattern": "^https://example\\.com/(?P<id>\\d+)", "args": ["--new-window", "${id}", "$$1"]},
+    {"match": {"schemes": ["https"], "host": "*.corp", "port": 8443}, "profile": "work",
+     "rewrite": {"pattern": "^http:", "replace": "https:"},
+     "url_params": {"hl": "en"},
+     "query": [{"op": "delete", "name": "utm_*"}, {"op": "set", "name": "x", "value": ""}]}
+  ]
+}`
+
+func decodeFormat(t *testing.T, data []byte, format Format) *Config {
+	t.Helper()
+	var config Config
+	if err := decodeConfigData(data, format, &config); err != nil {
+		t.Fatalf("decode %s: %v\n%s", format, err, data)
+	}
+	return &config
+}
+
+func encodeFormat(t *testing.T, config *Config, format Format) []byte {
+	t.Helper()
+	data, err := EncodeConfig(config, format)
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return data
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	original := decodeFormat(t, []byte(fullConfig), FormatJSON)
+	want := encodeFormat(t, original, FormatJSON)
+
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			converted := decodeFormat(t, encodeFormat(t, original, format), format)
+			if got := encodeFormat(t, converted, FormatJSON); !bytes.Equal(got, want) {
+				t.Errorf("round trip through %s changed the config:\n%s\nwant:\n%s", format, got, want)
+			}
+		})
+	}
+}
+
+func TestFormatChain(t *testing.T) {
+	config := decodeFormat(t, []byte(fullConfig), FormatJSON)
+	want := encodeFormat(t, config, FormatJSON)
+
+	for _, format := range []Format{FormatYAML, FormatTOML, FormatYAML, FormatJSON} {
+		config = decodeFormat(t, encodeFormat(t, config, format), format)
+	}
+	if got := encodeFormat(t, config, FormatJSON); !bytes.Equal(got, want) {
+		t.Errorf("JSON -> YAML -> TOML -> YAML -> JSON changed the config:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeOmitsUnset(t *testing.T) {
+	config := decodeFormat(t, []byte(`{"application": "/bin/b", "url_patterns": []}`), FormatJSON)
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		data := string(encodeFormat(t, config, format))
+		for _, field := range []string{"port", "tls", "auth", "default", "null"} {
+			if strings.Contains(data, field) {
+				t.Errorf("%s output contains %q:\n%s", format, field, data)
+			}
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]Format{
+		"config.json":       FormatJSON,
+		"config.yaml":       FormatYAML,
+		"config.YML":        FormatYAML,
+		"config.toml":       FormatTOML,
+		"config.last-good":  FormatJSON,
+		"dir.d/config.toml": FormatTOML,
+	}
+	for path, want := range tests {
+		if got := FormatOf(path); got != want {
+			t.Errorf("FormatOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// configDName is the drop-in directory next to the config file whose
+// files are merged automatically, in file name order
+const configDName = "config.d"
+
+// fileData is the content of a config file
+type fileData struct {
+	path string
+	data []byte
+}
+
+// setSource records the file and index of every pattern
+func (c *Config) setSource(path string) {
+	for i := range c.URLPatterns {
+		c.URLPatterns[i].Source = path
+		c.URLPatterns[i].SourceIndex = i
+	}
+}
+
+// IncludedFiles returns the files merged into the config at configPath:
+// the include list in order, followed by config.d/* sorted by name.
+// Relative include paths and globs are resolved against the directory
+// of the config file.
+func (c *Config) IncludedFiles(configPath string) ([]string, error) {
+	dir := filepath.Dir(configPath)
+
+	var files []string
+	for _, include := range c.Include {
+		include, err := expandValue(include, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include: %w", configPath, err)
+		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", configPath, include, err)
+		}
+		if len(matches) == 0 && !hasGlobMeta(include) {
+			return nil, fmt.Errorf("%s: include %q: file not found", configPath, include)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	if !c.NoConfigD {
+		files = append(files, configDFiles(dir)...)
+	}
+	return files, nil
+}
+
+// configDFiles returns the config files in the config.d directory
+func configDFiles(dir string) []string {
+	entries, err := os.ReadDir(filepath.Join(dir, configDName))
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".json", ".yaml", ".yml", ".toml":
+			files = append(files, filepath.Join(dir, configDName, entry.Name()))
+		}
+	}
+	return files
+}
+
+// mergeIncludes appends the url_patterns and applications of every
+// included file to the config and returns the content of those files
+func (c *Config) mergeIncludes(configPath string) ([]fileData, error) {
+	files, err := c.IncludedFiles(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []fileData
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var included Config
+		if err := decodeConfigData(data, FormatOf(path), &included); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := included.checkIncludable(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		included.setSource(path)
+
+		for name, app := range included.Applications {
+			if _, ok := c.Applications[name]; ok {
+				return nil, fmt.Errorf("%s: application %q is already defined", path, name)
+			}
+			if c.Applications == nil {
+				c.Applications = map[string]Application{}
+			}
+			c.Applications[name] = app
+		}
+		c.URLPatterns = append(c.URLPatterns, included.URLPatterns...)
+		loaded = append(loaded, fileData{path, data})
+	}
+	return loaded, nil
+}
+
+// checkIncludable reports settings that an included file may not change
+func (c *Config) checkIncludable() error {
+	if c.Application != "" || c.Listen != "" || len(c.AllowedClients) > 0 || len(c.AllowedOrigins) > 0 || len(c.AllowedSchemes) > 0 || c.MaxURLLength != 0 || c.AllowGet || c.Port != 0 || c.TLS != nil || c.Auth != nil || c.Default != nil || len(c.Include) > 0 || c.NoConfigD {
+		return fmt.Errorf("included files may only contain url_patterns and applications")
+	}
+	return nil
+}
+
+// ConfigFiles returns the config file and every file it includes.
+// Problems in the files are ignored so that a broken config can still
+// be watched for a fix.
+func ConfigFiles(configPath string) []string {
+	files := []string{configPath}
+
+	var config Config
+	if data, err := os.ReadFile(configPath); err == nil {
+		decodeConfigData(data, FormatOf(configPath), &config)
+	}
+	// IncludedFiles fails on a missing include; fall back to config.d only
+	included, err := config.IncludedFiles(configPath)
+	if err != nil {
+		if !config.NoConfigD {
+			included = configDFiles(filepath.Dir(configPath))
+		}
+	}
+	return append(files, included...)
+}
+
+// checksumFiles returns a checksum over the names and content of the files
+func checksumFiles(files []fileData) string {
+	var all []byte
+	for _, file := range files {
+		all = append(all, file.path...)
+		all = append(all, 0)
+		all = append(all, file.data...)
+		all = append(all, 0)
+	}
+	return checksum(all)
+}
+
+func hasGlobMeta(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// MatchCondition is a structured alternative to the pattern regex.
+// Every field that is set must match. When a pattern also has a regex,
+// both the regex and the conditions must match.
+type MatchCondition struct {
+	Schemes     []string          `json:"schemes,omitempty"`      // e.g. ["http", "https"]
+	Host        string            `json:"host,omitempty"`         // glob, e.g. "*.example.com"
+	HostSuffix  string            `json:"host_suffix,omitempty"`  // "example.com" matches example.com and www.example.com
+	PathPrefix  string            `json:"path_prefix,omitempty"`  // e.g. "/browse/"
+	Port        int               `json:"port,omitempty"`         // scheme default ports are taken into account
+	QueryHas    []string          `json:"query_has,omitempty"`    // query keys that must be present
+	QueryEquals map[string]string `json:"query_equals,omitempty"` // query keys that must have the given value
+}
+
+// Matches reports whether the URL satisfies all conditions
+func (m *MatchCondition) Matches(u *url.URL) bool {
+	return m.Explain(u) == ""
+}
+
+// Explain returns the first condition the URL does not satisfy,
+// or an empty string if it satisfies all of them
+func (m *MatchCondition) Explain(u *url.URL) string {
+	if u == nil {
+		return "URL could not be parsed"
+	}
+
+	if len(m.Schemes) > 0 {
+		found := false
+		for _, scheme := range m.Schemes {
+			if strings.EqualFold(scheme, u.Scheme) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("scheme %q is not one of %v", u.Scheme, m.Schemes)
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if m.Host != "" {
+		if matched, err := path.Match(strings.ToLower(m.Host), host); err != nil || !matched {
+			return fmt.Sprintf("host %q does not match %q", host, m.Host)
+		}
+	}
+	if m.HostSuffix != "" {
+		suffix := strings.ToLower(strings.TrimPrefix(m.HostSuffix, "."))
+		if host != suffix && !strings.HasSuffix(host, "."+suffix) {
+			return fmt.Sprintf("host %q does not end with %q", host, m.HostSuffix)
+		}
+	}
+
+	if m.PathPrefix != "" && !strings.HasPrefix(u.Path, m.PathPrefix) {
+		return fmt.Sprintf("path %q does not start with %q", u.Path, m.PathPrefix)
+	}
+
+	if m.Port != 0 && m.Port != urlPort(u) {
+		return fmt.Sprintf("port %d is not %d", urlPort(u), m.Port)
+	}
+
+	if len(m.QueryHas) > 0 || len(m.QueryEquals) > 0 {
+		query := u.Query()
+		for _, key := range m.QueryHas {
+			if !query.Has(key) {
+				return fmt.Sprintf("query parameter %q is missing", key)
+			}
+		}
+		for key, value := range m.QueryEquals {
+			if !query.Has(key) || query.Get(key) != value {
+				return fmt.Sprintf("query parameter %q is not %q", key, value)
+			}
+		}
+	}
+
+	return ""
+}
+
+// urlPort returns the port of the URL, or the default port of its scheme
+func urlPort(u *url.URL) int {
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
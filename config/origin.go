@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AnyOrigin in allowed_origins allows requests from every web page
+const AnyOrigin = "*"
+
+// OriginAllowed reports whether a browser origin such as
+// "https://example.com" or "chrome-extension://<id>" may use the server
+func (c *Config) OriginAllowed(origin string) bool {
+	origin = normalizeOrigin(origin)
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == AnyOrigin || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareAllowedOrigins checks and normalizes allowed_origins entries,
+// which are scheme://host[:port] without a path
+func (c *Config) prepareAllowedOrigins() error {
+	for i, entry := range c.AllowedOrigins {
+		if entry == AnyOrigin {
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("allowed_origins[%d]: %q is not an origin like https://example.com", i, entry)
+		}
+		c.AllowedOrigins[i] = normalizeOrigin(entry)
+	}
+	return nil
+}
+
+// normalizeOrigin lowercases the scheme and host and drops a trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
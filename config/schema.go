@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaURL is the JSON Schema dialect of the generated schema
+const SchemaURL = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEnums lists the allowed values of string fields, keyed by
+// "Type.Field"
+var schemaEnums = map[string][]string{
+	"QueryOp.Op":            {QueryOpSet, QueryOpReplace, QueryOpDefault, QueryOpDelete},
+	"DefaultRule.OnNoMatch": {NoMatchDefault, NoMatchReject, NoMatchSystem},
+	"AuthConfig.Mode":       {AuthToken, AuthHMAC},
+}
+
+// Schema returns a JSON Schema for config files. It is generated from the
+// Config struct, so new fields are covered as soon as they are added.
+func Schema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(Config{}))
+	schema["$schema"] = SchemaURL
+	schema["title"] = "openwith config"
+	return schema
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+			property := schemaFor(field.Type)
+			if enum, ok := schemaEnums[t.Name()+"."+field.Name]; ok {
+				property["enum"] = enum
+			}
+			properties[name] = property
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]any{}
+}
+
+// jsonFieldName returns the JSON name of a struct field, or an empty
+// string if the field is not encoded
+func jsonFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSchemaCoversStructs walks Config and fails when a field is missing
+// from the schema or has a different type, so the two cannot drift apart
+func TestSchemaCoversStructs(t *testing.T) {
+	checkSchemaType(t, "config", reflect.TypeOf(Config{}), Schema())
+}
+
+func checkSchemaType(t *testing.T, path string, typ reflect.Type, schema map[string]any) {
+	t.Helper()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	want := map[reflect.Kind]string{
+		reflect.String: "string",
+		reflect.Bool:   "boolean",
+		reflect.Int:    "integer",
+		reflect.Slice:  "array",
+		reflect.Map:    "object",
+		reflect.Struct: "object",
+	}[typ.Kind()]
+	if schema["type"] != want {
+		t.Errorf("%s: schema type %v, want %q", path, schema["type"], want)
+		return
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice:
+		checkSchemaType(t, path+"[]", typ.Elem(), schema["items"].(map[string]any))
+	case reflect.Map:
+		checkSchemaType(t, path+"{}", typ.Elem(), schema["additionalProperties"].(map[string]any))
+	case reflect.Struct:
+		properties := schema["properties"].(map[string]any)
+		fields := 0
+		for i := 0; i < typ.NumField(); i++ {
+			name := jsonFieldName(typ.Field(i))
+			if name == "" {
+				continue
+			}
+			fields++
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				t.Errorf("%s.%s: missing from the schema", path, name)
+				continue
+			}
+			checkSchemaType(t, path+"."+name, typ.Field(i).Type, property)
+		}
+		if fields != len(properties) {
+			t.Errorf("%s: schema has %d properties, struct has %d fields", path, len(properties), fields)
+		}
+	}
+}
+
+// enumValidators check a single value of each enum field the way loading
+// a config does, keyed like schemaEnums
+var enumValidators = map[string]struct {
+	prefix string   // prefix of the constants of the enum
+	path   []string // location of the field in the schema
+	valid  func(value string) bool
+}{
+	"QueryOp.Op": {"QueryOp", []string{"url_patterns", "query", "op"}, func(value string) bool {
+		return validateQueryOp(QueryOp{Op: value, Name: "name"}) == nil
+	}},
+	"DefaultRule.OnNoMatch": {"NoMatch", []string{"default", "on_no_match"}, func(value string) bool {
+		config := Config{Default: &DefaultRule{OnNoMatch: value}}
+		return value != "" && config.prepareDefault() == nil
+	}},
+	"AuthConfig.Mode": {"Auth", []string{"auth", "mode"}, func(value string) bool {
+		return (&AuthConfig{Mode: value, Secret: "secret"}).prepare() == nil
+	}},
+}
+
+// stringConstants returns the string constants declared in the package
+func stringConstants(t *testing.T) map[string]string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants := map[string]string{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range parsed.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				for i, name := range valueSpec.Names {
+					if i >= len(valueSpec.Values) {
+						continue
+					}
+					lit, ok := valueSpec.Values[i].(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					value, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					constants[name.Name] = value
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// TestSchemaEnums checks the schema enums against the validators. Every
+// enum value must be accepted when loading a config, and every constant
+// that loading accepts must be in the enum, so a value added to only one
+// side fails the test.
+func TestSchemaEnums(t *testing.T) {
+	constants := stringConstants(t)
+	properties := Schema()["properties"].(map[string]any)
+
+	for key, validator := range enumValidators {
+		property := properties
+		for i, name := range validator.path {
+			property = property[name].(map[string]any)
+			if items, ok := property["items"].(map[string]any); ok {
+				property = items
+			}
+			if i < len(validator.path)-1 {
+				property = property["properties"].(map[string]any)
+			}
+		}
+		enum, _ := property["enum"].([]string)
+		if len(enum) == 0 {
+			t.Errorf("%s: schema has no enum", key)
+		}
+
+		for _, value := range enum {
+			if !validator.valid(value) {
+				t.Errorf("%s: schema enum value %q is rejected when loading", key, value)
+			}
+		}
+		if validator.valid("bogus-value") {
+			t.Errorf("%s: an unknown value is accepted when loading", key)
+		}
+		for name, value := range constants {
+			if strings.HasPrefix(name, validator.prefix) && validator.valid(value) && !slices.Contains(enum, value) {
+				t.Errorf("%s: %s = %q is accepted when loading but missing from the schema enum", key, name, value)
+			}
+		}
+	}
+
+	for key := range schemaEnums {
+		if _, ok := enumValidators[key]; !ok {
+			t.Errorf("schemaEnums[%q] has no validator in the test", key)
+		}
+	}
+}
+
+// TestSampleMatchesSchema validates config.json.sample against the schema
+func TestSampleMatchesSchema(t *testing.T) {
+	data, err := os.ReadFile("../config.json.sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sample any
+	if err := json.Unmarshal(data, &sample); err != nil {
+		t.Fatal(err)
+	}
+	validateAgainstSchema(t, "config", Schema(), sample)
+}
+
+// validateAgainstSchema checks the parts of JSON Schema that Schema uses
+func validateAgainstSchema(t *testing.T, path string, schema map[string]any, value any) {
+	t.Helper()
+	if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, value.(string)) {
+		t.Errorf("%s: %v is not one of %v", path, value, enum)
+	}
+
+	switch schema["type"] {
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s: %v is not a string", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s: %v is not a boolean", path, value)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			t.Errorf("%s: %v is not an integer", path, value)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			t.Errorf("%s: %v is not an array", path, value)
+			return
+		}
+		for _, item := range items {
+			validateAgainstSchema(t, path+"[]", schema["items"].(map[string]any), item)
+		}
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			t.Errorf("%s: %v is not an object", path, value)
+			return
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, item := range object {
+			if property, ok := properties[name].(map[string]any); ok {
+				validateAgainstSchema(t, path+"."+name, property, item)
+			} else if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				validateAgainstSchema(t, path+"."+name, additional, item)
+			} else {
+				t.Errorf("%s: unknown property %q", path, name)
+			}
+		}
+	}
+}
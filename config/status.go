@@ -0,0 +1,124 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastGoodPath returns the path of the copy of the last config that loaded
+// successfully, e.g. config.last-good.json for config.json
+func lastGoodPath(configPath string) string {
+	ext := filepath.Ext(configPath)
+	return strings.TrimSuffix(configPath, ext) + ".last-good" + ext
+}
+
+// Status describes the active config and the result of the last reload
+type Status struct {
+	Path         string    `json:"path"`
+	Checksum     string    `json:"checksum"`
+	LoadedAt     time.Time `json:"loaded_at"`
+	FromLastGood bool      `json:"from_last_good"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at,omitzero"`
+}
+
+var (
+	status      Status
+	statusMutex sync.RWMutex
+)
+
+// GetStatus returns the current config status
+func GetStatus() Status {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	return status
+}
+
+// recordLoad records a successful load. A successful load of the config
+// file itself clears the last error and refreshes the last-good copy.
+func recordLoad(configPath string, config *Config, sum string, fromLastGood bool) {
+	statusMutex.Lock()
+	status.Path = configPath
+	status.Checksum = sum
+	status.LoadedAt = time.Now()
+	status.FromLastGood = fromLastGood
+	if !fromLastGood {
+		status.LastError = ""
+		status.LastErrorAt = time.Time{}
+	}
+	statusMutex.Unlock()
+
+	if !fromLastGood {
+		if err := saveLastGood(configPath, config); err != nil {
+			log.Printf("Failed to save last-good config: %v", err)
+		}
+	}
+}
+
+// saveLastGood writes the merged config as a single file, so the copy
+// does not depend on included files that may be broken later. The config
+// may contain secrets, so only the owner can read the copy.
+func saveLastGood(configPath string, config *Config) error {
+	if config.snapshot == nil {
+		return nil
+	}
+	path := lastGoodPath(configPath)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(config.snapshot)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordError records a failed load, keeping the active config status
+func recordError(configPath string, err error) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	status.Path = configPath
+	status.LastError = err.Error()
+	status.LastErrorAt = time.Now()
+}
+
+// LoadConfigWithFallback loads the config file for startup. When the file
+// is broken it falls back to the last config that loaded successfully.
+func LoadConfigWithFallback(configPath string) (*Config, error) {
+	config, sum, err := loadConfigData(configPath)
+	if err == nil {
+		recordLoad(configPath, config, sum, false)
+		return config, nil
+	}
+	recordError(configPath, err)
+
+	lastGood := lastGoodPath(configPath)
+	config, sum, lastGoodErr := loadConfigData(lastGood)
+	if lastGoodErr != nil {
+		return nil, err
+	}
+	log.Printf("Failed to load config file: %v", err)
+	log.Printf("Using last-good config: %s", lastGood)
+	recordLoad(configPath, config, sum, true)
+	return config, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
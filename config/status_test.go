@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLastGoodKeepsValuesUnexpanded(t *testing.T) {
+	t.Setenv("OPENWITH_TEST_TOKEN", "expanded-token")
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	data := `{
+		"application": "/usr/bin/browser",
+		"applications": {"work": {"path": "/usr/bin/chrome", "args": ["$${HOME}"], "env": {"TOKEN": "${OPENWITH_TEST_TOKEN}"}}},
+		"include": ["extra.json"],
+		"url_patterns": []
+	}`
+	if err := os.WriteFile(configPath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	extra := `{"url_patterns": [{"pattern": "^https://extra/", "profile": "work"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "extra.json"), []byte(extra), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfigWithFallback(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	lastGood := lastGoodPath(configPath)
+	info, err := os.Stat(lastGood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("last-good copy has mode %v, want 0600", perm)
+	}
+
+	saved, err := os.ReadFile(lastGood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"${OPENWITH_TEST_TOKEN}", "$${HOME}", "^https://extra/"} {
+		if !strings.Contains(string(saved), want) {
+			t.Errorf("last-good copy does not contain %q:\n%s", want, saved)
+		}
+	}
+	for _, unwanted := range []string{"expanded-token", "extra.json"} {
+		if strings.Contains(string(saved), unwanted) {
+			t.Errorf("last-good copy contains %q:\n%s", unwanted, saved)
+		}
+	}
+
+	// The copy loads to the same config as the original
+	original, err := LoadConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := LoadConfigFile(lastGood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(original, fallback); len(changes) > 0 {
+		t.Errorf("last-good copy differs from the config: %v", changes)
+	}
+	if got := fallback.Applications["work"].Args[0]; got != "${HOME}" {
+		t.Errorf("escaped arg loaded from the copy as %q, want %q", got, "${HOME}")
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultAllowedSchemes are the URL schemes accepted when allowed_schemes
+// is not set
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// DefaultMaxURLLength is the longest URL accepted when max_url_length is
+// not set
+const DefaultMaxURLLength = 8192
+
+// Names of the checks done by CheckURL
+const (
+	URLCheckLength     = "length"
+	URLCheckCharacters = "characters"
+	URLCheckArgument   = "argument"
+	URLCheckSyntax     = "syntax"
+	URLCheckScheme     = "scheme"
+)
+
+// URLError reports a URL that failed one of the safety checks
+type URLError struct {
+	Check   string
+	Message string
+}
+
+func (e *URLError) Error() string {
+	return e.Message
+}
+
+// Schemes returns the allowed URL schemes
+func (c *Config) Schemes() []string {
+	if len(c.AllowedSchemes) == 0 {
+		return DefaultAllowedSchemes
+	}
+	return c.AllowedSchemes
+}
+
+// MaxURLLen returns the longest URL that is accepted
+func (c *Config) MaxURLLen() int {
+	if c.MaxURLLength == 0 {
+		return DefaultMaxURLLength
+	}
+	return c.MaxURLLength
+}
+
+// CheckURL checks a requested URL before it is handed to an application.
+// Values starting with "-" are rejected because applications would parse
+// them as command line flags.
+func (c *Config) CheckURL(rawURL string) error {
+	if maxLen := c.MaxURLLen(); len(rawURL) > maxLen {
+		return &URLError{URLCheckLength, fmt.Sprintf("URL is longer than %d characters", maxLen)}
+	}
+	if strings.ContainsFunc(rawURL, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return &URLError{URLCheckCharacters, "URL contains control characters"}
+	}
+	if err := CheckArgument(rawURL); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &URLError{URLCheckSyntax, fmt.Sprintf("URL is not valid: %v", err)}
+	}
+	if u.Scheme == "" {
+		return &URLError{URLCheckScheme, "URL has no scheme"}
+	}
+	scheme := strings.ToLower(u.Scheme)
+	for _, allowed := range c.Schemes() {
+		if scheme == allowed {
+			return nil
+		}
+	}
+	return &URLError{URLCheckScheme, fmt.Sprintf("scheme %q is not allowed (allowed: %s)", scheme, strings.Join(c.Schemes(), ", "))}
+}
+
+// CheckArgument rejects a value built from the requested URL that an
+// application would read as a flag
+func CheckArgument(value string) error {
+	if strings.HasPrefix(strings.TrimSpace(value), "-") {
+		return &URLError{URLCheckArgument, fmt.Sprintf("argument %q built from the URL starts with '-'", value)}
+	}
+	return nil
+}
+
+// prepareURLChecks checks and normalizes allowed_schemes and max_url_length
+func (c *Config) prepareURLChecks() error {
+	if c.MaxURLLength < 0 {
+		return fmt.Errorf("max_url_length must not be negative")
+	}
+	for i, scheme := range c.AllowedSchemes {
+		scheme = strings.ToLower(strings.TrimSuffix(scheme, ":"))
+		u, err := url.Parse(scheme + ":")
+		if err != nil || u.Scheme != scheme {
+			return fmt.Errorf("allowed_schemes[%d]: invalid scheme %q", i, c.AllowedSchemes[i])
+		}
+		c.AllowedSchemes[i] = scheme
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ValidateFile checks a config file more strictly than LoadConfigFile.
+// In addition to the load checks it reports unknown fields and
+// application executables that cannot be found. It returns every
+// problem found, or nil if the file is valid.
+func ValidateFile(configPath string) []error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+
+	// Line numbers are only meaningful for JSON, other formats are
+	// checked after conversion
+	format := FormatOf(configPath)
+	if format != FormatJSON {
+		data, err = toJSONData(data, format)
+		if err != nil {
+			return []error{err}
+		}
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return []error{describeJSONError(data, err)}
+		}
+		if format != FormatJSON {
+			errs = append(errs, err)
+		} else if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			// The decoder does not report where the unknown field is,
+			// so look for its first occurrence as a key
+			offset := int64(bytes.Index(data, []byte(field)))
+			if offset < 0 {
+				offset = decoder.InputOffset()
+			}
+			line, column := lineColumn(data, offset)
+			errs = append(errs, fmt.Errorf("line %d, column %d: %w", line, column, err))
+		} else {
+			errs = append(errs, describeJSONError(data, err))
+		}
+
+		// Decode again without the strict checks to find the other problems
+		config = Config{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return errs
+		}
+	}
+
+	config.setSource(configPath)
+	if _, err := config.mergeIncludes(configPath); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, config.check()...)
+	}
+
+	for _, path := range config.executables() {
+		if _, err := exec.LookPath(path); err != nil {
+			errs = append(errs, fmt.Errorf("application %q not found", path))
+		}
+	}
+
+	return errs
+}
+
+// executables returns every application path referenced by the config
+func (c *Config) executables() []string {
+	seen := map[string]bool{}
+	var paths []string
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	add(c.Application)
+	if c.Default != nil {
+		add(c.Default.Application)
+	}
+	names := make([]string, 0, len(c.Applications))
+	for name := range c.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(c.Applications[name].Path)
+	}
+	for _, pattern := range c.URLPatterns {
+		add(pattern.Application)
+	}
+	return paths
+}
+
+// describeJSONError adds the line and column to JSON decoding errors
+func describeJSONError(data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		return err
+	}
+
+	line, column := lineColumn(data, offset)
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// lineColumn converts a byte offset into a 1-based line and column
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFileReportsEveryProblem(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	data := fmt.Sprintf(`{
+  "application": %q,
+  "port": 70000,
+  "unknown": true,
+  "default": {"on_no_match": "nope"},
+  "url_patterns": [
+    {"pattern": "(["},
+    {"pattern": "ok", "rewrite": {"pattern": "*", "replace": ""}, "profile": "missing"}
+  ]
+}`, executable)
+	if err := os.WriteFile(configPath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := ValidateFile(configPath)
+	want := []string{
+		`line 4, column 3: json: unknown field "unknown"`,
+		"port 70000",
+		`default: unknown on_no_match "nope"`,
+		"url_patterns[0].pattern",
+		"url_patterns[1].rewrite",
+		`url_patterns[1]: unknown profile "missing"`,
+	}
+	if len(errs) != len(want) {
+		t.Errorf("got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i := range min(len(errs), len(want)) {
+		if !strings.Contains(errs[i].Error(), want[i]) {
+			t.Errorf("error %d is %q, want it to contain %q", i, errs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollInterval is used when file system notifications are not available
+const pollInterval = 5 * time.Second
+
+// debounceDelay collapses the burst of events editors produce on save
+const debounceDelay = 300 * time.Millisecond
+
+// ConfigUpdateCallback is the function type for config update callbacks.
+// An error means the new config could not be applied completely.
+type ConfigUpdateCallback func(*Config) error
+
+// WatchConfigFile monitors config file changes and calls the callback when updated.
+// Included files and the config.d directory are watched too. It uses file
+// system notifications when available and falls back to polling. The
+// config is only reloaded when the content of its files changes. It
+// returns when ctx is done.
+func WatchConfigFile(ctx context.Context, configPath string, configMutex *sync.RWMutex, appConfig **Config, callback ConfigUpdateCallback) {
+	w := &configWatcher{
+		configPath:  configPath,
+		configMutex: configMutex,
+		appConfig:   appConfig,
+		callback:    callback,
+	}
+	w.files = ConfigFiles(configPath)
+	w.lastHash, _ = filesHash(w.files)
+
+	if err := w.watchEvents(ctx); err != nil {
+		log.Printf("File system notifications unavailable, polling config file: %v", err)
+		w.poll(ctx)
+	}
+}
+
+type configWatcher struct {
+	configPath  string
+	configMutex *sync.RWMutex
+	appConfig   **Config
+	callback    ConfigUpdateCallback
+	files       []string
+	lastHash    string
+}
+
+// watchEvents watches the directories of the config files so that editors
+// replacing a file with a rename are noticed too
+func (w *configWatcher) watchEvents(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(w.configPath)); err != nil {
+		return err
+	}
+	w.addWatches(watcher)
+
+	configD := filepath.Join(filepath.Dir(w.configPath), configDName)
+	debounce := time.NewTimer(debounceDelay)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			name := filepath.Clean(event.Name)
+			if !w.isWatched(name) && filepath.Dir(name) != configD && name != configD {
+				continue
+			}
+			debounce.Reset(debounceDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Config watcher error: %v", err)
+		case <-debounce.C:
+			w.reloadIfChanged()
+			w.addWatches(watcher)
+		}
+	}
+}
+
+// addWatches watches the directories of the current config files and the
+// config.d directory. Directories that are already watched are skipped by
+// fsnotify.
+func (w *configWatcher) addWatches(watcher *fsnotify.Watcher) {
+	dirs := []string{filepath.Join(filepath.Dir(w.configPath), configDName)}
+	for _, file := range w.files {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Failed to watch %s: %v", dir, err)
+		}
+	}
+}
+
+func (w *configWatcher) isWatched(name string) bool {
+	for _, file := range w.files {
+		if filepath.Clean(file) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *configWatcher) poll(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged reloads the config when the content of its files changed
+func (w *configWatcher) reloadIfChanged() {
+	// Includes may have been added or removed
+	w.files = ConfigFiles(w.configPath)
+	hash, err := filesHash(w.files)
+	if err != nil {
+		// A file may be missing for a moment while it is replaced
+		return
+	}
+	if hash == w.lastHash {
+		return
+	}
+	w.lastHash = hash
+
+	log.Println("Config file changed, reloading...")
+	newConfig, sum, err := loadConfigData(w.configPath)
+	if err != nil {
+		recordError(w.configPath, err)
+		log.Printf("Failed to reload config, keeping the current one: %v", err)
+		return
+	}
+	recordLoad(w.configPath, newConfig, sum, false)
+
+	w.configMutex.Lock()
+	oldConfig := *w.appConfig
+	*w.appConfig = newConfig
+	w.configMutex.Unlock()
+
+	if oldConfig != nil {
+		logDiff(Diff(oldConfig, newConfig))
+	}
+
+	// Call the callback with the new config
+	if w.callback != nil {
+		if err := w.callback(newConfig); err != nil {
+			recordError(w.configPath, err)
+			log.Printf("Config reloaded, but applying it failed: %v", err)
+			return
+		}
+	}
+
+	log.Println("Config reloaded successfully")
+}
+
+// logDiff logs the changes made by a reload
+func logDiff(changes []string) {
+	if len(changes) == 0 {
+		log.Println("Config changes: none")
+		return
+	}
+	log.Printf("Config changes (%d):", len(changes))
+	for _, change := range changes {
+		log.Printf("  %s", change)
+	}
+}
+
+// filesHash returns a checksum over the content of the files
+func filesHash(paths []string) (string, error) {
+	files := make([]fileData, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, fileData{path, data})
+	}
+	return checksumFiles(files), nil
+}
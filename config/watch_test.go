@@ -0,0 +1,123 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startWatcher runs WatchConfigFile on a config in a temp dir and returns
+// the config path and the configs passed to the callback
+func startWatcher(t *testing.T) (string, <-chan *Config) {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	writeApplication(t, configPath, "initial")
+
+	appConfig, err := LoadConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan *Config, 100)
+	done := make(chan struct{})
+	var mutex sync.RWMutex
+	go func() {
+		defer close(done)
+		WatchConfigFile(ctx, configPath, &mutex, &appConfig, func(newConfig *Config) error {
+			reloads <- newConfig
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Give the watcher time to add its watches
+	time.Sleep(100 * time.Millisecond)
+	return configPath, reloads
+}
+
+func writeApplication(t *testing.T, path, application string) {
+	t.Helper()
+	data := fmt.Sprintf(`{"application": %q, "url_patterns": []}`, application)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitReload returns the next reloaded config, failing after a timeout
+func waitReload(t *testing.T, reloads <-chan *Config) *Config {
+	t.Helper()
+	select {
+	case config := <-reloads:
+		return config
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+		return nil
+	}
+}
+
+// expectNoReload fails if the config is reloaded within a few debounce delays
+func expectNoReload(t *testing.T, reloads <-chan *Config) {
+	t.Helper()
+	select {
+	case config := <-reloads:
+		t.Fatalf("unexpected reload with application %q", config.Application)
+	case <-time.After(3 * debounceDelay):
+	}
+}
+
+func TestWatchDebouncesRapidWrites(t *testing.T) {
+	configPath, reloads := startWatcher(t)
+
+	for i := range 10 {
+		writeApplication(t, configPath, fmt.Sprintf("write-%d", i))
+		time.Sleep(debounceDelay / 10)
+	}
+
+	if config := waitReload(t, reloads); config.Application != "write-9" {
+		t.Errorf("reloaded application %q, want the last write", config.Application)
+	}
+	expectNoReload(t, reloads)
+}
+
+func TestWatchReloadsReplacedFile(t *testing.T) {
+	configPath, reloads := startWatcher(t)
+
+	// Editors save by writing a new file and renaming it over the old one
+	for _, application := range []string{"renamed-1", "renamed-2"} {
+		tmp := configPath + ".tmp"
+		writeApplication(t, tmp, application)
+		if err := os.Rename(tmp, configPath); err != nil {
+			t.Fatal(err)
+		}
+		if config := waitReload(t, reloads); config.Application != application {
+			t.Errorf("reloaded application %q, want %q", config.Application, application)
+		}
+	}
+}
+
+func TestWatchSkipsUnchangedContent(t *testing.T) {
+	configPath, reloads := startWatcher(t)
+
+	// Touching the file without changing it does not reload
+	writeApplication(t, configPath, "initial")
+	expectNoReload(t, reloads)
+
+	// A broken config is not passed on
+	if err := os.WriteFile(configPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoReload(t, reloads)
+
+	writeApplication(t, configPath, "fixed")
+	if config := waitReload(t, reloads); config.Application != "fixed" {
+		t.Errorf("reloaded application %q, want %q", config.Application, "fixed")
+	}
+}
@@ -3,9 +3,12 @@ module openwith
 go 1.24.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/kardianos/service v1.2.4
 	github.com/labstack/echo/v4 v4.13.4
 	golang.org/x/text v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"openwith/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Headers of HMAC signed requests. The signature is the hex encoded
+// HMAC-SHA256 of "<timestamp>\n<body>" keyed with the shared secret.
+// GET requests have no body and sign the raw query string instead.
+const (
+	TimestampHeader = "X-Openwith-Timestamp"
+	SignatureHeader = "X-Openwith-Signature"
+)
+
+// maxSignedBodySize is the largest body of a signed request
+const maxSignedBodySize = 64 << 10
+
+// replayCache remembers the signatures seen within the allowed clock skew
+// so that a captured request cannot be sent again
+type replayCache struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+// add records a signature and reports whether it was new
+func (r *replayCache) add(signature string, expires time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for key, expiry := range r.seen {
+		if now.After(expiry) {
+			delete(r.seen, key)
+		}
+	}
+
+	if _, ok := r.seen[signature]; ok {
+		return false
+	}
+	r.seen[signature] = expires
+	return true
+}
+
+// Authenticate checks the shared secret of requests when auth is configured
+func (h *Handler) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auth := h.GetConfig().Auth
+		if auth == nil {
+			return next(c)
+		}
+
+		var err error
+		switch auth.Mode {
+		case config.AuthToken:
+			err = checkToken(c.Request(), auth.Key, isGetOpen(c))
+		case config.AuthHMAC:
+			err = h.checkSignature(c.Request(), auth)
+		default:
+			err = fmt.Errorf("unknown auth mode %q", auth.Mode)
+		}
+
+		if err != nil {
+			log.Printf("Rejected request from %s: %v", c.Request().RemoteAddr, err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Request body too large"})
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		}
+		return next(c)
+	}
+}
+
+// TokenParam is the query parameter that carries the token for clients
+// that cannot set headers, such as bookmarklets and links. It is only
+// accepted on GET /open, as URLs end up in browser history and logs.
+const TokenParam = "access_token"
+
+func checkToken(req *http.Request, key []byte, allowQuery bool) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok && allowQuery {
+		token = req.URL.Query().Get(TokenParam)
+	}
+	if token == "" {
+		return fmt.Errorf("missing bearer token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), key) != 1 {
+		return fmt.Errorf("invalid bearer token")
+	}
+	return nil
+}
+
+func (h *Handler) checkSignature(req *http.Request, auth *config.AuthConfig) error {
+	timestamp := req.Header.Get(TimestampHeader)
+	signature, err := hex.DecodeString(req.Header.Get(SignatureHeader))
+	if timestamp == "" || err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing or malformed signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	maxSkew := time.Duration(auth.MaxSkew) * time.Second
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("timestamp outside the allowed skew of %v", maxSkew)
+	}
+
+	// Read the body for the signature and put it back for Bind. The body
+	// is read before the client is authenticated, so its size is limited.
+	body, err := io.ReadAll(http.MaxBytesReader(nil, req.Body, maxSignedBodySize))
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if req.Method == http.MethodGet {
+		body = []byte(req.URL.RawQuery)
+	}
+
+	if !hmac.Equal(signature, Sign(auth.Key, timestamp, body)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if !h.replays.add(hex.EncodeToString(signature), signedAt.Add(maxSkew)) {
+		return fmt.Errorf("replayed request")
+	}
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 signature of a request body
+func Sign(key []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
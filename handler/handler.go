@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,9 +11,11 @@ import (
 	"openwith/windows"
 	"os"
 	"os/exec"
+	"path"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -20,6 +23,7 @@ import (
 type Handler struct {
 	configMutex *sync.RWMutex
 	appConfig   *config.Config
+	replays     *replayCache
 }
 
 // NewHandler creates a new handler instance
@@ -27,6 +31,7 @@ func NewHandler(configMutex *sync.RWMutex, appConfig *config.Config) *Handler {
 	return &Handler{
 		configMutex: configMutex,
 		appConfig:   appConfig,
+		replays:     &replayCache{seen: map[string]time.Time{}},
 	}
 }
 
@@ -44,101 +49,315 @@ func (h *Handler) UpdateConfig(newConfig *config.Config) {
 	h.appConfig = newConfig
 }
 
-// Handle handles POST requests to open URLs with configured applications
+// HandleStatus reports the active config and the result of the last reload
+func (h *Handler) HandleStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, config.GetStatus())
+}
+
+// setStatusHeaders adds the config status to the response headers
+func setStatusHeaders(c echo.Context) {
+	status := config.GetStatus()
+	header := c.Response().Header()
+	header.Set("X-Openwith-Config-Checksum", status.Checksum)
+	if status.FromLastGood {
+		header.Set("X-Openwith-Config-Fallback", "last-good")
+	}
+	if status.LastError != "" {
+		header.Set("X-Openwith-Config-Error", strings.ReplaceAll(status.LastError, "\n", " "))
+	}
+}
+
+// OpenPath is the path of the open endpoint for simple clients
+const OpenPath = "/open"
+
+// isGetOpen reports whether the request is GET /open
+func isGetOpen(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet && c.Path() == OpenPath
+}
+
+// HandleOpenGet handles GET /open for bookmarklets and clients that can
+// only follow links. It takes the url and dry_run query parameters and is
+// disabled unless allow_get is set, which requires auth. Bookmarklets pass
+// the token as the access_token query parameter.
+func (h *Handler) HandleOpenGet(c echo.Context) error {
+	if !h.GetConfig().AllowGet {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "GET " + OpenPath + " is disabled, set allow_get to enable it"})
+	}
+	return h.Handle(c)
+}
+
+// Handle handles requests to open URLs with configured applications. It
+// serves POST / and POST /open with a JSON body, and GET /open through
+// HandleOpenGet.
 func (h *Handler) Handle(c echo.Context) error {
 	log.Println("-------------------------------------------------------")
+	body, res, reqErr := h.resolveRequest(c)
+	if reqErr != nil {
+		return c.JSON(reqErr.status, reqErr.body)
+	}
+	if res.HandledBy == HandledByRejected {
+		log.Println("No pattern matched, rejected")
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":      "No url_patterns entry matched the URL",
+			"url":        body.URL,
+			"handled_by": res.HandledBy,
+		})
+	}
+	if body.DryRun {
+		return c.JSON(http.StatusOK, newResolveResponse(res))
+	}
+
+	if err := h.executeCommand(res.Application, res.CommandArgs()); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Cannot start application: %v", err)})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":      "URL opened successfully",
+		"url":          res.URL,
+		"original_url": body.URL,
+		"application":  res.Application.Path,
+		"args":         fmt.Sprintf("%v", res.Args),
+		"handled_by":   res.HandledBy,
+	})
+}
+
+// HandleResolve handles POST /resolve and reports how the URL would be
+// opened without launching the application
+func (h *Handler) HandleResolve(c echo.Context) error {
+	_, res, reqErr := h.resolveRequest(c)
+	if reqErr != nil {
+		return c.JSON(reqErr.status, reqErr.body)
+	}
+	if res.HandledBy == HandledByRejected {
+		return c.JSON(http.StatusNotFound, newResolveResponse(res))
+	}
+	return c.JSON(http.StatusOK, newResolveResponse(res))
+}
+
+// requestError is the response to a request that cannot be handled
+type requestError struct {
+	status int
+	body   map[string]string
+}
+
+// resolveRequest reads the request, checks the URL and resolves it. Handle
+// and HandleResolve share it so that a dry run reports exactly what Handle
+// would do.
+func (h *Handler) resolveRequest(c echo.Context) (RequestBody, *Resolution, *requestError) {
+	setStatusHeaders(c)
 	var body RequestBody
 	if err := c.Bind(&body); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		message := "Invalid JSON"
+		if c.Request().Method == http.MethodGet {
+			message = "Invalid query parameters"
+		}
+		return body, nil, &requestError{http.StatusBadRequest, map[string]string{"error": message}}
 	}
 
 	log.Println("url :", body.URL)
 	if body.URL == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "URL parameter is required"})
+		return body, nil, &requestError{http.StatusBadRequest, map[string]string{"error": "URL parameter is required"}}
 	}
 
 	appConfig := h.GetConfig()
-	args, modifiedURL := h.processURL(body.URL, appConfig)
-	cmdArgs := h.buildCommandArgs(args, modifiedURL)
+	if err := appConfig.CheckURL(body.URL); err != nil {
+		return body, nil, urlRejected(body.URL, err)
+	}
 
-	if err := h.executeCommand(cmdArgs, appConfig); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Cannot start application: %v", err)})
+	res := h.processURL(body.URL, appConfig)
+	if res.HandledBy == HandledByRejected {
+		return body, res, nil
+	}
+	// Rewrites and templates may have turned parts of the URL into args
+	if err := res.CheckArgs(); err != nil {
+		return body, nil, urlRejected(res.URL, err)
 	}
+	return body, res, nil
+}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "URL opened successfully",
-		"url":         body.URL,
-		"application": appConfig.Application,
-		"args":        fmt.Sprintf("%v", args),
-	})
+// urlRejected is the response to a URL that failed a safety check,
+// naming the check
+func urlRejected(rawURL string, err error) *requestError {
+	log.Printf("Rejected URL: %v", err)
+	check := ""
+	var urlErr *config.URLError
+	if errors.As(err, &urlErr) {
+		check = urlErr.Check
+	}
+	return &requestError{http.StatusBadRequest, map[string]string{
+		"error": err.Error(),
+		"check": check,
+		"url":   rawURL,
+	}}
 }
 
-func (h *Handler) processURL(originalURL string, appConfig *config.Config) ([]string, string) {
-	var args []string
-	modifiedURL := originalURL
+func newResolveResponse(res *Resolution) ResolveResponse {
+	args := res.Args
+	if args == nil {
+		args = []string{}
+	}
+	return ResolveResponse{
+		Message:      "Dry run, application not started",
+		URL:          res.URL,
+		OriginalURL:  res.OriginalURL,
+		Application:  res.Application.Path,
+		Args:         args,
+		CommandArgs:  res.CommandArgs(),
+		PatternIndex: res.PatternIndex,
+		HandledBy:    res.HandledBy,
+	}
+}
+
+// Resolve works out how the URL would be opened with the current
+// configuration without launching anything
+func (h *Handler) Resolve(originalURL string) *Resolution {
+	return h.processURL(originalURL, h.GetConfig())
+}
+
+// processURL finds the first matching pattern and returns the application,
+// args and modified URL to use. The application falls back to
+// Config.Application when no pattern matches.
+func (h *Handler) processURL(originalURL string, appConfig *config.Config) *Resolution {
+	res := &Resolution{
+		OriginalURL:  originalURL,
+		URL:          originalURL,
+		PatternIndex: -1,
+	}
+
+	parsedURL, err := url.Parse(originalURL)
+	if err != nil {
+		parsedURL = nil
+	}
 
-	for _, pattern := range appConfig.URLPatterns {
-		if pattern.CompiledReg == nil {
+	for i := range appConfig.URLPatterns {
+		pattern := &appConfig.URLPatterns[i]
+		matches, reason := pattern.ExplainMatch(originalURL, parsedURL)
+		if reason != "" {
+			res.Trace = append(res.Trace, PatternTrace{Index: i, Source: pattern.Source, Pattern: pattern.Pattern, Reason: reason})
 			continue
 		}
-		if !pattern.CompiledReg.MatchString(originalURL) {
-			continue
+
+		res.URL = h.rewriteURL(originalURL, pattern.Rewrite)
+		res.URL = h.modifyURLParams(res.URL, pattern.QueryOps())
+		h.buildArgs(res, pattern.Args, newTemplateVars(res.URL, pattern.CompiledReg, matches))
+		res.Application = appConfig.ApplicationFor(pattern)
+		res.PatternIndex = i
+		res.HandledBy = HandledByRule
+		return res
+	}
+
+	h.applyNoMatchPolicy(res, appConfig)
+	return res
+}
+
+// applyNoMatchPolicy fills in the resolution for a URL that no pattern matched
+func (h *Handler) applyNoMatchPolicy(res *Resolution, appConfig *config.Config) {
+	switch appConfig.NoMatchPolicy() {
+	case config.NoMatchReject:
+		res.HandledBy = HandledByRejected
+	case config.NoMatchSystem:
+		res.Application = systemOpener()
+		res.HandledBy = HandledBySystem
+	default:
+		res.Application = appConfig.DefaultApplication()
+		if appConfig.Default != nil {
+			h.buildArgs(res, appConfig.Default.Args, newTemplateVars(res.URL, nil, nil))
 		}
+		res.HandledBy = HandledByDefault
+	}
+}
 
-		modifiedURL = h.modifyURLParams(originalURL, pattern.URLParams)
-		args = h.buildArgs(pattern.Args, modifiedURL)
-		break
+// systemOpener returns the command that opens a URL with the system default handler
+func systemOpener() config.Application {
+	switch runtime.GOOS {
+	case "windows":
+		return config.Application{Path: "rundll32", Args: []string{"url.dll,FileProtocolHandler"}}
+	case "darwin":
+		return config.Application{Path: "open"}
 	}
+	return config.Application{Path: "xdg-open"}
+}
 
-	return args, modifiedURL
+// rewriteURL applies the regex rewrite of a pattern to the URL
+func (h *Handler) rewriteURL(originalURL string, rewrite *config.URLRewrite) string {
+	if rewrite == nil || rewrite.CompiledReg == nil {
+		return originalURL
+	}
+	return rewrite.CompiledReg.ReplaceAllString(originalURL, rewrite.Replace)
 }
 
-func (h *Handler) modifyURLParams(originalURL string, urlParams map[string]string) string {
-	if len(urlParams) == 0 {
+// modifyURLParams applies the query operations to the URL.
+// Parameters that are not targeted keep their original order and encoding.
+func (h *Handler) modifyURLParams(originalURL string, ops []config.QueryOp) string {
+	if len(ops) == 0 {
 		return originalURL
 	}
 
-	parsedURL, err := url.Parse(originalURL)
-	if err != nil {
+	if _, err := url.Parse(originalURL); err != nil {
 		return originalURL
 	}
 
-	query := parsedURL.Query()
-	for key, value := range urlParams {
-		if query.Has(key) {
-			query.Set(key, value)
+	base, rawQueryString, fragment := splitURL(originalURL)
+	query := parseRawQuery(rawQueryString)
+	for _, op := range ops {
+		match := func(key string) bool { return matchQueryName(op.Name, key) }
+		if op.Exact {
+			match = func(key string) bool { return key == op.Name }
+		}
+		switch op.Op {
+		case config.QueryOpSet:
+			query.Set(op.Name, op.Value)
+		case config.QueryOpDefault:
+			if !query.Has(op.Name) {
+				query.Set(op.Name, op.Value)
+			}
+		case config.QueryOpReplace:
+			query.Replace(match, op.Value)
+		case config.QueryOpDelete:
+			query.Delete(match)
 		}
 	}
-	parsedURL.RawQuery = query.Encode()
-	return parsedURL.String()
-}
 
-func (h *Handler) buildArgs(patternArgs []string, modifiedURL string) []string {
-	args := make([]string, len(patternArgs))
-	for i, arg := range patternArgs {
-		args[i] = strings.Replace(arg, "$url", modifiedURL, -1)
+	encoded := query.String()
+	if encoded == "" {
+		return base + fragment
 	}
-	return args
+	return base + "?" + encoded + fragment
 }
 
-func (h *Handler) buildCommandArgs(args []string, modifiedURL string) []string {
-	if len(args) > 0 {
-		return args
-	}
-	return []string{modifiedURL}
+// matchQueryName reports whether a query key matches a name or glob
+func matchQueryName(pattern, key string) bool {
+	matched, err := path.Match(pattern, key)
+	return err == nil && matched
 }
 
-func (h *Handler) executeCommand(cmdArgs []string, appConfig *config.Config) error {
-	app := appConfig.Application
+// buildArgs expands the template variables in pattern args. The templates
+// are kept with the args, as CheckArgs needs them to tell flags written in
+// the config from flags that came from the URL.
+func (h *Handler) buildArgs(res *Resolution, patternArgs []string, vars *templateVars) {
+	res.Args = make([]string, len(patternArgs))
+	for i, arg := range patternArgs {
+		res.Args[i] = expandTemplate(arg, vars)
+	}
+	res.argTemplates = patternArgs
+}
 
+func (h *Handler) executeCommand(app config.Application, cmdArgs []string) error {
 	// Check if running as service and try to execute in user session
 	serviceMode := os.Getenv("SERVICE_MODE") == "true"
 	if serviceMode && runtime.GOOS == "windows" {
-		return windows.ExecuteCommandInUserSession(app, cmdArgs)
+		return windows.ExecuteCommandInUserSession(app.Path, cmdArgs, app.Env, app.Dir)
 	}
 
-	cmd := exec.Command(app, cmdArgs...)
-	log.Printf("Executing command: %s %s\n", app, strings.Join(cmdArgs, " "))
+	cmd := exec.Command(app.Path, cmdArgs...)
+	cmd.Dir = app.Dir
+	if len(app.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range app.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	log.Printf("Executing command: %s %s\n", app.Path, strings.Join(cmdArgs, " "))
 	
 	// Execute command and capture output
 	output, err := cmd.CombinedOutput()
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"openwith/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// loadTestConfig loads a config from JSON, as the server would
+func loadTestConfig(t *testing.T, data string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	appConfig, err := config.LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appConfig
+}
+
+// postDryRun sends a dry run to Handle and returns the status and response
+func postDryRun(t *testing.T, h *Handler, rawURL string) (int, map[string]any) {
+	t.Helper()
+	body, _ := json.Marshal(RequestBody{URL: rawURL, DryRun: true})
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	e := echo.New()
+	e.POST("/", h.Handle)
+	e.ServeHTTP(rec, req)
+
+	var response map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body, err)
+	}
+	return rec.Code, response
+}
+
+func TestHandleArgumentChecks(t *testing.T) {
+	appConfig := loadTestConfig(t, `{
+		"application": "/usr/bin/browser",
+		"default": {"args": ["--new-window", "$url"]},
+		"url_patterns": [
+			{"pattern": "^https://search\\.corp/", "args": ["--incognito", "$query.q"]},
+			{"pattern": "^https://jira\\.corp/browse/(?P<key>.+)", "args": ["${key}"]}
+		]
+	}`)
+	h := NewHandler(&sync.RWMutex{}, appConfig)
+
+	tests := []struct {
+		name        string
+		url         string
+		want        int
+		commandArgs []string
+	}{
+		{"default args with a flag", "https://other.com/", http.StatusOK, []string{"--new-window", "https://other.com/"}},
+		{"rule args with a flag", "https://search.corp/?q=go", http.StatusOK, []string{"--incognito", "go"}},
+		{"flag from a query template", "https://search.corp/?q=--renderer-cmd-prefix=/tmp/evil", http.StatusBadRequest, nil},
+		{"flag from a named group", "https://jira.corp/browse/--utility-cmd-prefix=x", http.StatusBadRequest, nil},
+		{"named group", "https://jira.corp/browse/ABC-1", http.StatusOK, []string{"ABC-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := postDryRun(t, h, tt.url)
+			if status != tt.want {
+				t.Fatalf("status %d, want %d: %v", status, tt.want, response)
+			}
+			if status != http.StatusOK {
+				if response["check"] != config.URLCheckArgument {
+					t.Errorf("check %v, want %q", response["check"], config.URLCheckArgument)
+				}
+				return
+			}
+			var commandArgs []string
+			for _, arg := range response["command_args"].([]any) {
+				commandArgs = append(commandArgs, arg.(string))
+			}
+			if !slices.Equal(commandArgs, tt.commandArgs) {
+				t.Errorf("command args %q, want %q", commandArgs, tt.commandArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientAllowlist rejects clients whose address is not in allowed_clients.
+// The address of the TCP connection is used, not X-Forwarded-For, which
+// any client could set.
+func (h *Handler) ClientAllowlist(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		remoteAddr := c.Request().RemoteAddr
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			host = remoteAddr
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil || !h.GetConfig().ClientAllowed(ip) {
+			log.Printf("Rejected request from %s: client not allowed", remoteAddr)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Client not allowed"})
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// corsAllowHeaders are the request headers browsers may send to the server
+const corsAllowHeaders = "Content-Type, Authorization, " + TimestampHeader + ", " + SignatureHeader
+
+// OriginProtection stops web pages from using the server through the
+// user's browser. Requests that carry an Origin or Referer header must come
+// from one of allowed_origins, and POST bodies must be JSON, which a
+// cross-site form cannot send without a CORS preflight. Clients that are
+// not browsers send neither header and are not affected.
+func (h *Handler) OriginProtection(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		appConfig := h.GetConfig()
+
+		// Bookmarklets open GET /open from whatever page is shown, so
+		// allow_get relies on auth, which it requires, instead of origins
+		if appConfig.AllowGet && isGetOpen(c) {
+			return next(c)
+		}
+
+		// Forms and other simple requests can be sent cross-site without a
+		// preflight, so they are rejected before looking at the origin
+		if req.Method == http.MethodPost && !isJSON(req) {
+			log.Printf("Rejected request from %s: content type %q is not JSON", req.RemoteAddr, req.Header.Get("Content-Type"))
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "Content-Type must be application/json"})
+		}
+
+		origin := requestOrigin(req)
+		if origin != "" {
+			if !appConfig.OriginAllowed(origin) {
+				log.Printf("Rejected request from %s: origin %q not allowed", req.RemoteAddr, origin)
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Origin not allowed"})
+			}
+			header := c.Response().Header()
+			header.Add("Vary", "Origin")
+			if req.Header.Get("Origin") != "" {
+				header.Set("Access-Control-Allow-Origin", req.Header.Get("Origin"))
+			}
+		}
+
+		if req.Method == http.MethodOptions {
+			header := c.Response().Header()
+			header.Set("Access-Control-Allow-Methods", "GET, POST")
+			header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		return next(c)
+	}
+}
+
+// requestOrigin returns the web origin a request was made from, taken from
+// the Origin header or else the Referer. A present but opaque origin such
+// as "null" is returned as is, so it never matches allowed_origins.
+// A page can hide its Referer, so a request that the browser marks as
+// made by another site is treated as opaque as well. Browsers without
+// Fetch Metadata cannot be told apart from other clients this way, which
+// is why GET /open needs allow_get and auth.
+func requestOrigin(req *http.Request) string {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	referer := req.Header.Get("Referer")
+	if referer == "" {
+		switch req.Header.Get("Sec-Fetch-Site") {
+		case "", "none", "same-origin":
+			return ""
+		}
+		return "null"
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "null"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func isJSON(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
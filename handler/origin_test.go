@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"openwith/config"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const allowedOrigin = "https://allowed.example"
+
+// newOriginTestServer returns an echo instance with OriginProtection in
+// front of handlers that always succeed
+func newOriginTestServer(appConfig *config.Config) *echo.Echo {
+	h := NewHandler(&sync.RWMutex{}, appConfig)
+	ok := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+
+	e := echo.New()
+	e.Use(h.OriginProtection)
+	e.POST("/", ok)
+	e.GET("/status", ok)
+	e.GET(OpenPath, ok)
+	return e
+}
+
+func TestOriginProtection(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	const json = "application/json"
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		headers     map[string]string
+		want        int
+	}{
+		{"cross-site form post", http.MethodPost, "/", form, map[string]string{"Origin": "https://evil.example"}, http.StatusUnsupportedMediaType},
+		{"cross-site form post with referer", http.MethodPost, "/", form, map[string]string{"Referer": "https://evil.example/page"}, http.StatusUnsupportedMediaType},
+		{"cross-site text post", http.MethodPost, "/", "text/plain", map[string]string{"Origin": "https://evil.example"}, http.StatusUnsupportedMediaType},
+		{"multipart post", http.MethodPost, "/", "multipart/form-data; boundary=x", nil, http.StatusUnsupportedMediaType},
+		{"post without content type", http.MethodPost, "/", "", nil, http.StatusUnsupportedMediaType},
+		{"disallowed origin", http.MethodPost, "/", json, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"disallowed referer", http.MethodPost, "/", json, map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+		{"opaque origin", http.MethodPost, "/", json, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"hidden referer of a cross-site request", http.MethodPost, "/", json, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"disallowed origin on get", http.MethodGet, "/status", "", map[string]string{"Referer": "https://evil.example/"}, http.StatusForbidden},
+		{"get open without allow_get", http.MethodGet, OpenPath, "", map[string]string{"Referer": "https://evil.example/"}, http.StatusForbidden},
+		{"allowed origin", http.MethodPost, "/", json, map[string]string{"Origin": allowedOrigin}, http.StatusOK},
+		{"allowed origin with other case", http.MethodPost, "/", json + "; charset=utf-8", map[string]string{"Origin": "HTTPS://Allowed.Example"}, http.StatusOK},
+		{"allowed referer", http.MethodPost, "/", json, map[string]string{"Referer": allowedOrigin + "/page?x=1"}, http.StatusOK},
+		{"non-browser client", http.MethodPost, "/", json, nil, http.StatusOK},
+		{"address bar navigation", http.MethodGet, "/status", "", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+	}
+
+	e := newOriginTestServer(&config.Config{AllowedOrigins: []string{allowedOrigin}})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"url":"https://example.com"}`))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestOriginProtectionPreflight(t *testing.T) {
+	e := newOriginTestServer(&config.Config{AllowedOrigins: []string{allowedOrigin}})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight(allowedOrigin)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("allowed preflight: status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	header := rec.Header()
+	if got := header.Get("Access-Control-Allow-Origin"); got != allowedOrigin {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, allowedOrigin)
+	}
+	if got := header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPost) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want POST", got)
+	}
+	if got := header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Content-Type") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want Content-Type", got)
+	}
+	if got := header.Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+
+	rec = preflight("https://evil.example")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("disallowed preflight: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed preflight: Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+func TestOriginProtectionAllowGet(t *testing.T) {
+	e := newOriginTestServer(&config.Config{AllowGet: true})
+
+	// Bookmarklets navigate from the page they run on
+	req := httptest.NewRequest(http.MethodGet, OpenPath+"?url=https://example.com", nil)
+	req.Header.Set("Referer", "https://news.example/article")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET %s with allow_get: status %d, want %d", OpenPath, rec.Code, http.StatusOK)
+	}
+
+	// Other routes still check the origin
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Referer", "https://news.example/article")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("GET /status from another site: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
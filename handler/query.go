@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+)
+
+// rawParam is one key=value segment of a query string
+type rawParam struct {
+	key string // decoded key used for matching
+	raw string // segment exactly as it appeared in the URL
+}
+
+// rawQuery edits a query string in place. Unlike url.Values it keeps the
+// order and encoding of parameters that are not touched, so signed URLs
+// stay valid after unrelated parameters are changed.
+type rawQuery struct {
+	params []rawParam
+}
+
+func parseRawQuery(query string) *rawQuery {
+	q := &rawQuery{}
+	if query == "" {
+		return q
+	}
+	for _, segment := range strings.Split(query, "&") {
+		rawKey, _, _ := strings.Cut(segment, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		q.params = append(q.params, rawParam{key: key, raw: segment})
+	}
+	return q
+}
+
+// String returns the encoded query string
+func (q *rawQuery) String() string {
+	segments := make([]string, len(q.params))
+	for i, p := range q.params {
+		segments[i] = p.raw
+	}
+	return strings.Join(segments, "&")
+}
+
+// Has reports whether the query contains the key
+func (q *rawQuery) Has(key string) bool {
+	for _, p := range q.params {
+		if p.raw != "" && p.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Set replaces the first occurrence of key with value and removes the rest.
+// The parameter is appended if it does not exist yet.
+func (q *rawQuery) Set(key, value string) {
+	if !q.Has(key) {
+		q.params = append(q.params, rawParam{key: key, raw: url.QueryEscape(key) + "=" + url.QueryEscape(value)})
+		return
+	}
+	q.Replace(func(k string) bool { return k == key }, value)
+}
+
+// Replace sets the value of every present key accepted by match,
+// keeping the original spelling of the key.
+func (q *rawQuery) Replace(match func(string) bool, value string) {
+	seen := map[string]bool{}
+	params := q.params[:0]
+	for _, p := range q.params {
+		if p.raw == "" || !match(p.key) {
+			params = append(params, p)
+			continue
+		}
+		if seen[p.key] {
+			continue
+		}
+		seen[p.key] = true
+		rawKey, _, _ := strings.Cut(p.raw, "=")
+		params = append(params, rawParam{key: p.key, raw: rawKey + "=" + url.QueryEscape(value)})
+	}
+	q.params = params
+}
+
+// Delete removes every key accepted by match
+func (q *rawQuery) Delete(match func(string) bool) {
+	params := q.params[:0]
+	for _, p := range q.params {
+		if p.raw != "" && match(p.key) {
+			continue
+		}
+		params = append(params, p)
+	}
+	q.params = params
+}
+
+// splitURL splits a URL into the part before the query, the raw query
+// and the fragment including its leading '#'
+func splitURL(rawURL string) (base, query, fragment string) {
+	base = rawURL
+	if i := strings.IndexByte(base, '#'); i >= 0 {
+		base, fragment = base[:i], base[i:]
+	}
+	base, query, _ = strings.Cut(base, "?")
+	return base, query, fragment
+}
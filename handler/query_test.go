@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"openwith/config"
+	"strings"
+	"testing"
+)
+
+// A presigned S3 URL; its signature breaks if any other parameter is
+// reordered or re-encoded
+const signedQuery = "X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+	"&X-Amz-Credential=AKIAEXAMPLE%2F20240101%2Fus-east-1%2Fs3%2Faws4_request" +
+	"&X-Amz-Date=20240101T000000Z&X-Amz-Expires=300&X-Amz-SignedHeaders=host" +
+	"&X-Amz-Signature=0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+
+func TestParseRawQueryRoundTrip(t *testing.T) {
+	tests := []string{
+		"",
+		"a=1",
+		"b=2&a=1",
+		signedQuery,
+		"q=a+b&path=%2Fx%2Fy&plus=%2B",
+		"a=1&a=2&a=1",
+		"a=1&&b=2&",
+		"flag&a=&=v",
+		"sig=abc==&bad=%zz",
+	}
+	for _, query := range tests {
+		if got := parseRawQuery(query).String(); got != query {
+			t.Errorf("parseRawQuery(%q).String() = %q", query, got)
+		}
+	}
+}
+
+func TestRawQueryHas(t *testing.T) {
+	q := parseRawQuery("flag&a=1&utm%5Fsource=x&&")
+	for key, want := range map[string]bool{"flag": true, "a": true, "utm_source": true, "utm%5Fsource": false, "": false, "b": false} {
+		if got := q.Has(key); got != want {
+			t.Errorf("Has(%q) = %t, want %t", key, got, want)
+		}
+	}
+}
+
+func TestRawQueryEdits(t *testing.T) {
+	isKey := func(name string) func(string) bool {
+		return func(key string) bool { return key == name }
+	}
+	hasPrefix := func(prefix string) func(string) bool {
+		return func(key string) bool { return strings.HasPrefix(key, prefix) }
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		edit  func(q *rawQuery)
+		want  string
+	}{
+		{"set appends", "a=1", func(q *rawQuery) { q.Set("b", "v") }, "a=1&b=v"},
+		{"set on empty query", "", func(q *rawQuery) { q.Set("b", "v") }, "b=v"},
+		{"set encodes value", "a=1", func(q *rawQuery) { q.Set("a", "x y/z+") }, "a=x+y%2Fz%2B"},
+		{"set keeps position", "a=1&b=2&c=3", func(q *rawQuery) { q.Set("b", "x") }, "a=1&b=x&c=3"},
+		{"set drops duplicates", "a=1&b=2&a=3", func(q *rawQuery) { q.Set("a", "x") }, "a=x&b=2"},
+		{"set after signed params", signedQuery, func(q *rawQuery) { q.Set("x", "1") }, signedQuery + "&x=1"},
+		{"replace keeps key spelling", "utm%5Fsource=x&b=2", func(q *rawQuery) { q.Replace(isKey("utm_source"), "y") }, "utm%5Fsource=y&b=2"},
+		{"replace absent key", "a=1", func(q *rawQuery) { q.Replace(isKey("b"), "x") }, "a=1"},
+		{"replace key without value", "flag&a=1", func(q *rawQuery) { q.Replace(isKey("flag"), "on") }, "flag=on&a=1"},
+		{"replace keeps other encoding", "sig=abc%3D%3D&a=1&p=%2F", func(q *rawQuery) { q.Replace(isKey("a"), "2") }, "sig=abc%3D%3D&a=2&p=%2F"},
+		{"replace glob", "utm_source=a&id=5&utm_medium=b", func(q *rawQuery) { q.Replace(hasPrefix("utm_"), "x") }, "utm_source=x&id=5&utm_medium=x"},
+		{"delete duplicates", "a=1&b=2&a=3", func(q *rawQuery) { q.Delete(isKey("a")) }, "b=2"},
+		{"delete keeps empty segments", "utm_source=a&id=5&&utm_medium=b&x", func(q *rawQuery) { q.Delete(hasPrefix("utm_")) }, "id=5&&x"},
+		{"delete everything", "a=1&a=2", func(q *rawQuery) { q.Delete(isKey("a")) }, ""},
+		{"delete from signed params", signedQuery + "&utm_source=mail", func(q *rawQuery) { q.Delete(hasPrefix("utm_")) }, signedQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseRawQuery(tt.query)
+			tt.edit(q)
+			if got := q.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitURL(t *testing.T) {
+	tests := []struct {
+		url, base, query, fragment string
+	}{
+		{"https://h/p", "https://h/p", "", ""},
+		{"https://h/p?a=1", "https://h/p", "a=1", ""},
+		{"https://h/p?a=1#top", "https://h/p", "a=1", "#top"},
+		{"https://h/p#frag?x=1", "https://h/p", "", "#frag?x=1"},
+		{"https://h/p?#", "https://h/p", "", "#"},
+	}
+	for _, tt := range tests {
+		base, query, fragment := splitURL(tt.url)
+		if base != tt.base || query != tt.query || fragment != tt.fragment {
+			t.Errorf("splitURL(%q) = %q, %q, %q, want %q, %q, %q", tt.url, base, query, fragment, tt.base, tt.query, tt.fragment)
+		}
+	}
+}
+
+func TestModifyURLParams(t *testing.T) {
+	signedURL := "https://bucket.s3.amazonaws.com/key?" + signedQuery
+	tests := []struct {
+		name string
+		url  string
+		ops  []config.QueryOp
+		want string
+	}{
+		{
+			"signed URL keeps order and encoding",
+			signedURL + "&utm_source=mail#top",
+			[]config.QueryOp{{Op: config.QueryOpDelete, Name: "utm_*"}},
+			signedURL + "#top",
+		},
+		{
+			"set before fragment",
+			"https://h/p?a=1#top",
+			[]config.QueryOp{{Op: config.QueryOpSet, Name: "b", Value: "2"}},
+			"https://h/p?a=1&b=2#top",
+		},
+		{
+			"default only when absent",
+			"https://h/p?hl=de",
+			[]config.QueryOp{{Op: config.QueryOpDefault, Name: "hl", Value: "en"}, {Op: config.QueryOpDefault, Name: "gl", Value: "us"}},
+			"https://h/p?hl=de&gl=us",
+		},
+		{
+			"delete last param drops question mark",
+			"https://h/p?utm_source=x#f",
+			[]config.QueryOp{{Op: config.QueryOpDelete, Name: "utm_source"}},
+			"https://h/p#f",
+		},
+		{
+			"url_params keys are not globs",
+			"https://h/p?filter[type]=old&filter%5Btype%5D=old2&filtert=x&a[=1",
+			(&config.URLPattern{URLParams: map[string]string{"filter[type]": "new", "a[": "2", "filter*": "x"}}).QueryOps(),
+			"https://h/p?filter[type]=new&filtert=x&a[=2",
+		},
+		{
+			"query names are globs",
+			"https://h/p?filter[type]=old&filtert=x",
+			[]config.QueryOp{{Op: config.QueryOpDelete, Name: "filter[t]*"}},
+			"https://h/p?filter[type]=old",
+		},
+		{
+			"query in fragment untouched",
+			"https://h/p#/route?utm_source=x",
+			[]config.QueryOp{{Op: config.QueryOpDelete, Name: "utm_source"}},
+			"https://h/p#/route?utm_source=x",
+		},
+	}
+
+	h := &Handler{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.modifyURLParams(tt.url, tt.ops); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
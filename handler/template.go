@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateVars holds the values that can be referenced from pattern args.
+//
+//	$url, $scheme, $host, $port, $path, $fragment  parts of the URL
+//	$query.NAME                                     value of query parameter NAME
+//	$1, ${1}, ${name}                               capture groups of the pattern
+//	$$                                              a literal dollar sign
+type templateVars struct {
+	url     string
+	parsed  *url.URL
+	reg     *regexp.Regexp
+	matches []string
+}
+
+func newTemplateVars(rawURL string, reg *regexp.Regexp, matches []string) *templateVars {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		parsed = nil
+	}
+	return &templateVars{
+		url:     rawURL,
+		parsed:  parsed,
+		reg:     reg,
+		matches: matches,
+	}
+}
+
+// lookup returns the value of a template variable and whether it is known
+func (v *templateVars) lookup(name string) (string, bool) {
+	if name == "url" {
+		return v.url, true
+	}
+
+	if index, err := strconv.Atoi(name); err == nil {
+		if index >= 0 && index < len(v.matches) {
+			return v.matches[index], true
+		}
+		return "", false
+	}
+
+	if v.parsed != nil {
+		switch name {
+		case "scheme":
+			return v.parsed.Scheme, true
+		case "host":
+			return v.parsed.Hostname(), true
+		case "port":
+			return v.parsed.Port(), true
+		case "path":
+			return v.parsed.Path, true
+		case "fragment":
+			return v.parsed.Fragment, true
+		}
+		if key, ok := strings.CutPrefix(name, "query."); ok {
+			return v.parsed.Query().Get(key), true
+		}
+	}
+
+	if v.reg != nil {
+		if index := v.reg.SubexpIndex(name); index >= 0 && index < len(v.matches) {
+			return v.matches[index], true
+		}
+	}
+
+	return "", false
+}
+
+// expandTemplate replaces template variables in tmpl.
+// Unknown variables are left as they are.
+func expandTemplate(tmpl string, vars *templateVars) string {
+	var sb strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '$' || i+1 >= len(tmpl) {
+			sb.WriteByte(tmpl[i])
+			continue
+		}
+
+		next := tmpl[i+1]
+		if next == '$' {
+			sb.WriteByte('$')
+			i++
+			continue
+		}
+
+		var name string
+		end := i + 1
+		switch {
+		case next == '{':
+			closing := strings.IndexByte(tmpl[i+2:], '}')
+			if closing < 0 {
+				sb.WriteByte(tmpl[i])
+				continue
+			}
+			name = tmpl[i+2 : i+2+closing]
+			end = i + 2 + closing + 1
+		case isDigit(next):
+			for end < len(tmpl) && isDigit(tmpl[end]) {
+				end++
+			}
+			name = tmpl[i+1 : end]
+		default:
+			for end < len(tmpl) && isNameChar(tmpl[end]) {
+				end++
+			}
+			name = tmpl[i+1 : end]
+			// $query.NAME
+			if name == "query" && end < len(tmpl) && tmpl[end] == '.' {
+				end++
+				for end < len(tmpl) && (isNameChar(tmpl[end]) || tmpl[end] == '-') {
+					end++
+				}
+				name = tmpl[i+1 : end]
+			}
+		}
+
+		value, ok := vars.lookup(name)
+		if !ok {
+			sb.WriteString(tmpl[i:end])
+		} else {
+			sb.WriteString(value)
+		}
+		i = end - 1
+	}
+	return sb.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || isDigit(c) || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
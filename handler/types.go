@@ -1,5 +1,84 @@
 package handler
 
+import (
+	"openwith/config"
+	"strings"
+)
+
+// RequestBody is the JSON body of POST requests. GET /open takes the same
+// fields as query parameters.
 type RequestBody struct {
-	URL string `json:"url"`
-}
\ No newline at end of file
+	URL    string `json:"url" query:"url"`
+	DryRun bool   `json:"dry_run" query:"dry_run"`
+}
+
+// ResolveResponse is returned for dry runs and by the /resolve endpoint
+type ResolveResponse struct {
+	Message      string   `json:"message"`
+	URL          string   `json:"url"`
+	OriginalURL  string   `json:"original_url"`
+	Application  string   `json:"application"`
+	Args         []string `json:"args"`
+	CommandArgs  []string `json:"command_args"`
+	PatternIndex int      `json:"pattern_index"`
+	HandledBy    string   `json:"handled_by"`
+}
+
+// What handled a URL
+const (
+	HandledByRule     = "rule"     // a url_patterns entry matched
+	HandledByDefault  = "default"  // the default application
+	HandledBySystem   = "system"   // the system opener
+	HandledByRejected = "rejected" // nothing matched and the URL was rejected
+)
+
+// Resolution describes how a URL is opened
+type Resolution struct {
+	OriginalURL  string
+	URL          string // URL after rewrite and query operations
+	Application  config.Application
+	Args         []string // expanded args of the matched pattern
+	PatternIndex int      // index of the matched pattern, -1 if none matched
+	HandledBy    string
+	Trace        []PatternTrace
+
+	argTemplates []string // pattern args before expansion
+}
+
+// PatternTrace records why a pattern before the matched one was skipped
+type PatternTrace struct {
+	Index   int
+	Source  string // file the pattern came from
+	Pattern string
+	Reason  string
+}
+
+// CheckArgs rejects command args that the requested URL turned into
+// flags, e.g. "$query.q" expanded from "?q=--renderer-cmd-prefix=...".
+// Args whose template itself starts with "-" are flags written in the
+// config and are allowed.
+func (r *Resolution) CheckArgs() error {
+	if len(r.Args) == 0 {
+		return config.CheckArgument(r.URL)
+	}
+	for i, arg := range r.Args {
+		if i < len(r.argTemplates) && strings.HasPrefix(strings.TrimSpace(r.argTemplates[i]), "-") {
+			continue
+		}
+		if err := config.CheckArgument(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommandArgs returns the arguments passed to the application.
+// Profile base args come first, followed by the pattern args or the URL
+// itself when the matched pattern has no args.
+func (r *Resolution) CommandArgs() []string {
+	args := append([]string{}, r.Application.Args...)
+	if len(r.Args) > 0 {
+		return append(args, r.Args...)
+	}
+	return append(args, r.URL)
+}
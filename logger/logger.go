@@ -53,7 +53,12 @@ func InitializeWithMode(serviceMode bool) error {
 
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		return err
+		// The executable directory may be read-only (e.g. /usr/local/bin),
+		// so fall back to the user cache directory
+		logFile, err = openFallbackLog()
+		if err != nil {
+			return err
+		}
 	}
 
 	// For service mode, only write to file. For interactive mode, write to both console and file
@@ -71,6 +76,19 @@ func InitializeWithMode(serviceMode bool) error {
 	return nil
 }
 
+// openFallbackLog opens application.log in the user cache directory
+func openFallbackLog() (*os.File, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	logDir := filepath.Join(cacheDir, "openwith")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(logDir, "application.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+}
+
 // ConvertToUTF8 converts byte slice to UTF-8 string, handling Japanese encoding if needed
 func ConvertToUTF8(data []byte) string {
 	// First check if it's already valid UTF-8
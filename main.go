@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"openwith/config"
 	"os"
+	"path/filepath"
 
 	"github.com/kardianos/service"
-	"github.com/labstack/echo/v4"
 )
 
 var Name = "OpenWith"
@@ -14,16 +17,17 @@ var DisplayName = "OpenWith"
 var Description = "OpenWith Service"
 
 // perfv.go Run (mac だと認識してくれないので変数に入れてから呼ぶ)
-var Run func() *echo.Echo
+var Run func(ctx context.Context) *Server
 
-func doRun() *echo.Echo {
-	return Run()
+func doRun(ctx context.Context) *Server {
+	return Run(ctx)
 }
 
 var serviceLogger service.Logger
 
 type pgservice struct {
-	exit chan struct{}
+	exit   chan struct{}
+	cancel context.CancelFunc
 }
 
 func (e *pgservice) Start(s service.Service) error {
@@ -35,14 +39,16 @@ func (e *pgservice) Start(s service.Service) error {
 		os.Setenv("SERVICE_MODE", "true")
 	}
 	e.exit = make(chan struct{})
-	go e.run()
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go e.run(ctx)
 
 	return nil
 }
 
-func (e *pgservice) run() error {
+func (e *pgservice) run(ctx context.Context) error {
 
-	sv := doRun()
+	sv := doRun(ctx)
 
 	for {
 		select {
@@ -57,17 +63,40 @@ func (e *pgservice) run() error {
 }
 
 func (e *pgservice) Stop(s service.Service) error {
+	e.cancel()
 	close(e.exit)
 	return nil
 }
 
 func main() {
 
+	configPath := flag.String("config", "", "path to config file (default $"+config.ConfigPathEnv+", then search)")
+	flag.Parse()
+	args := flag.Args()
+
+	// The installed service is started with the same --config
+	var serviceArgs []string
+	if *configPath != "" {
+		absPath, err := filepath.Abs(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.SetConfigPath(absPath)
+		serviceArgs = []string{"--config", absPath}
+	}
+
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			os.Exit(command(args[1:]))
+		}
+	}
+
 	program := &pgservice{}
 	s, err := service.New(program, &service.Config{
 		Name:        Name,
 		DisplayName: DisplayName,
 		Description: Description,
+		Arguments:   serviceArgs,
 	})
 
 	if err != nil {
@@ -81,13 +110,13 @@ func main() {
 		log.Fatal()
 	}
 
-	if len(os.Args) > 1 {
-		err = service.Control(s, os.Args[1])
+	if len(args) > 0 {
+		err = service.Control(s, args[0])
 		if err != nil {
-			fmt.Printf("Failed (%s) : %s\n", os.Args[1], err)
+			fmt.Printf("Failed (%s) : %s\n", args[0], err)
 			return
 		}
-		fmt.Printf("Succeeded (%s)\n", os.Args[1])
+		fmt.Printf("Succeeded (%s)\n", args[0])
 		return
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,9 +10,6 @@ import (
 	"openwith/logger"
 	"os"
 	"sync"
-
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 )
 
 func init() {
@@ -53,44 +51,44 @@ func logBoxMessage(format string, args ...any) {
 	log.Print(border)
 }
 
-func MainRun() *echo.Echo {
+// MainRun starts the server in the background and returns it.
+// The config watcher stops when ctx is done.
+func MainRun(ctx context.Context) *Server {
 	// Initialize logger first (check if running as service)
 	serviceMode := os.Getenv("SERVICE_MODE") == "true"
 	if err := logger.InitializeWithMode(serviceMode); err != nil {
 		log.Printf("Failed to initialize logger: %v", err)
 	}
 
-	var err error
-	appConfig, err = config.LoadConfig()
+	configPath, err := config.GetConfigPath()
+	if err != nil {
+		log.Fatal("Failed to get config path:", err)
+	}
+	log.Printf("Config file: %s", configPath)
+
+	appConfig, err = config.LoadConfigWithFallback(configPath)
 	if err != nil {
 		log.Fatal("Failed to load config file:", err)
 	}
 
 	// Setup handler
 	h := handler.NewHandler(&configMutex, appConfig)
+	server := NewServer(h)
 
-	// Start config file watching with callback to update handler
-	go config.WatchConfigFile(&configMutex, &appConfig, func(newConfig *config.Config) {
+	// Start config file watching with callback to update handler and listener
+	go config.WatchConfigFile(ctx, configPath, &configMutex, &appConfig, func(newConfig *config.Config) error {
 		h.UpdateConfig(newConfig)
+		return server.Apply(newConfig)
 	})
 
-	e := echo.New()
-	// e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-
-	e.POST("/", h.Handle)
-
-	port := ":44525"
 	configMutex.RLock()
-	if appConfig.Port != 0 {
-		port = fmt.Sprintf(":%d", appConfig.Port)
-	}
+	address := appConfig.ListenAddress()
 	configMutex.RUnlock()
 
-	logBoxMessage("Starting server on port %s", port)
+	logBoxMessage("Starting server on %s", address)
 
 	// Log configuration details as formatted JSON
-	configJSON, err := json.MarshalIndent(appConfig, "", "  ")
+	configJSON, err := json.MarshalIndent(appConfig.Redacted(), "", "  ")
 	if err != nil {
 		log.Printf("Failed to marshal config to JSON: %v", err)
 	} else {
@@ -98,7 +96,12 @@ func MainRun() *echo.Echo {
 		log.Printf("%s", string(configJSON))
 	}
 
-	e.Logger.Fatal(e.Start(port))
+	configMutex.RLock()
+	err = server.Start(appConfig)
+	configMutex.RUnlock()
+	if err != nil {
+		log.Fatal("Failed to start server:", err)
+	}
 
-	return e
+	return server
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"openwith/config"
+	"openwith/handler"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// drainTimeout is how long in-flight requests on an old listener may take
+// to finish after the server has been rebound
+const drainTimeout = 30 * time.Second
+
+// listenerSettings are the config values that require a new listener
+type listenerSettings struct {
+	address string
+	tls     config.TLSConfig
+}
+
+func listenerSettingsOf(appConfig *config.Config) listenerSettings {
+	settings := listenerSettings{address: appConfig.ListenAddress()}
+	if appConfig.TLS != nil {
+		settings.tls = *appConfig.TLS
+	}
+	return settings
+}
+
+// Server runs the HTTP server and rebinds it when the listener settings
+// change on config reload
+type Server struct {
+	mutex    sync.Mutex
+	handler  *handler.Handler
+	http     *http.Server
+	listener net.Listener
+	settings listenerSettings
+	draining map[*http.Server]bool // old servers finishing in-flight requests
+}
+
+func NewServer(h *handler.Handler) *Server {
+	return &Server{handler: h, draining: map[*http.Server]bool{}}
+}
+
+// newEcho creates the echo instance with the routes of the server
+func (s *Server) newEcho() *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	// e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+	e.Use(s.handler.ClientAllowlist)
+	// Preflight requests carry no credentials, so origins are checked first
+	e.Use(s.handler.OriginProtection)
+	e.Use(s.handler.Authenticate)
+
+	e.POST("/", s.handler.Handle)
+	e.POST(handler.OpenPath, s.handler.Handle)
+	e.GET(handler.OpenPath, s.handler.HandleOpenGet)
+	e.POST("/resolve", s.handler.HandleResolve)
+	e.GET("/status", s.handler.HandleStatus)
+
+	return e
+}
+
+// Start binds the listener and serves in the background
+func (s *Server) Start(appConfig *config.Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := listenerSettingsOf(appConfig)
+	srv, ln, err := s.listen(settings)
+	if err != nil {
+		return err
+	}
+	s.http, s.listener, s.settings = srv, ln, settings
+	return nil
+}
+
+// Apply rebinds the server when the listener settings of the new config
+// differ from the current ones. In-flight requests of the old server are
+// drained in the background. When the port stays the same, the old
+// listener holds it and is closed before the new one is bound; if binding
+// then fails, the old settings are bound again.
+func (s *Server) Apply(appConfig *config.Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := listenerSettingsOf(appConfig)
+	if settings == s.settings {
+		return nil
+	}
+
+	logBoxMessage("Listener changed, rebinding %s -> %s", s.settings.address, settings.address)
+	samePort := port(settings.address) == port(s.settings.address)
+	if samePort && s.listener != nil {
+		s.listener.Close()
+	}
+
+	srv, ln, err := s.listen(settings)
+	if err != nil {
+		err = fmt.Errorf("failed to rebind %s: %w", settings.address, err)
+		if !samePort {
+			log.Printf("Keeping %s: %v", s.settings.address, err)
+			return err
+		}
+
+		// The old listener is already closed, so bind its settings again
+		oldServer := s.http
+		srv, ln, restoreErr := s.listen(s.settings)
+		if restoreErr != nil {
+			s.http, s.listener = nil, nil
+			err = fmt.Errorf("%w; restoring %s failed too: %v", err, s.settings.address, restoreErr)
+		} else {
+			s.http, s.listener = srv, ln
+		}
+		s.drain(s.settings.address, oldServer)
+		return err
+	}
+
+	oldAddress, oldServer := s.settings.address, s.http
+	s.http, s.listener, s.settings = srv, ln, settings
+	s.drain(oldAddress, oldServer)
+	return nil
+}
+
+// drain shuts down an old server in the background once its in-flight
+// requests are done. Close stops it right away.
+func (s *Server) drain(address string, srv *http.Server) {
+	if srv == nil {
+		return
+	}
+	s.draining[srv] = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		// The listener may have been closed already for a rebind
+		if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Failed to drain old listener %s: %v", address, err)
+		} else {
+			log.Printf("Old listener %s closed", address)
+		}
+
+		s.mutex.Lock()
+		delete(s.draining, srv)
+		s.mutex.Unlock()
+	}()
+}
+
+// Close stops the server and any old servers still draining immediately
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for srv := range s.draining {
+		srv.Close()
+		delete(s.draining, srv)
+	}
+	if s.http == nil {
+		return nil
+	}
+	return s.http.Close()
+}
+
+// port returns the port of a host:port address
+func port(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return port
+}
+
+// listen binds the address and starts serving on it
+func (s *Server) listen(settings listenerSettings) (*http.Server, net.Listener, error) {
+	srv := &http.Server{Handler: s.newEcho()}
+	useTLS := settings.tls.CertFile != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(settings.tls.CertFile, settings.tls.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	ln, err := net.Listen("tcp", settings.address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		// A listener closed for a rebind ends Serve with net.ErrClosed
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Server on %s stopped: %v", settings.address, err)
+		}
+	}()
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	log.Printf("Listening on %s://%s", scheme, ln.Addr())
+	return srv, ln, nil
+}
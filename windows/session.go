@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"unicode/utf16"
 	"unsafe"
 )
 
@@ -87,8 +88,10 @@ type PROCESS_INFORMATION struct {
 	ThreadId  uint32
 }
 
-// ExecuteCommandInUserSession executes a command in the active user session
-func ExecuteCommandInUserSession(app string, cmdArgs []string) error {
+// ExecuteCommandInUserSession executes a command in the active user session.
+// env is added to the environment of the user and dir is the working
+// directory, when set.
+func ExecuteCommandInUserSession(app string, cmdArgs []string, env map[string]string, dir string) error {
 	if runtime.GOOS != "windows" {
 		return fmt.Errorf("user session execution is only supported on Windows")
 	}
@@ -110,14 +113,19 @@ func ExecuteCommandInUserSession(app string, cmdArgs []string) error {
 	log.Printf("Found active session ID: %d", sessionId)
 	
 	// Build command line
-	cmdLine := fmt.Sprintf(`"%s" %s`, app, strings.Join(cmdArgs, " "))
+	// Quote every arg so that spaces in a URL cannot split it into flags
+	quoted := make([]string, len(cmdArgs))
+	for i, arg := range cmdArgs {
+		quoted[i] = syscall.EscapeArg(arg)
+	}
+	cmdLine := fmt.Sprintf(`"%s" %s`, app, strings.Join(quoted, " "))
 	cmdLinePtr, err := syscall.UTF16PtrFromString(cmdLine)
 	if err != nil {
 		return err
 	}
 	
 	// Execute in user session
-	err = createProcessInSession(sessionId, cmdLinePtr)
+	err = createProcessInSession(sessionId, cmdLinePtr, env, dir)
 	if err != nil {
 		log.Printf("Failed to create process in session: %v", err)
 		return err
@@ -155,7 +163,7 @@ func getActiveSessionId() (uint32, error) {
 	return 0xFFFFFFFF, fmt.Errorf("no active session found")
 }
 
-func createProcessInSession(sessionId uint32, cmdLine *uint16) error {
+func createProcessInSession(sessionId uint32, cmdLine *uint16, env map[string]string, dir string) error {
 	// Get user token for the session directly from WTS
 	var userToken syscall.Handle
 	ret, _, lastErr := procWTSQueryUserToken.Call(
@@ -189,7 +197,7 @@ func createProcessInSession(sessionId uint32, cmdLine *uint16) error {
 	log.Printf("Successfully duplicated token to primary token")
 	
 	// Create environment block for the user
-	var envBlock uintptr
+	var envBlock *uint16
 	ret, _, lastErr = procCreateEnvironmentBlock.Call(
 		uintptr(unsafe.Pointer(&envBlock)),
 		uintptr(primaryToken),
@@ -199,9 +207,25 @@ func createProcessInSession(sessionId uint32, cmdLine *uint16) error {
 		log.Printf("CreateEnvironmentBlock failed: %v", lastErr)
 		return fmt.Errorf("CreateEnvironmentBlock failed: %v", lastErr)
 	}
-	defer procDestroyEnvironmentBlock.Call(envBlock)
+	defer procDestroyEnvironmentBlock.Call(uintptr(unsafe.Pointer(envBlock)))
 	
 	log.Printf("Successfully created environment block")
+
+	// Add the env of the application to the environment of the user
+	environment := envBlock
+	if len(env) > 0 {
+		merged := mergeEnvironment(envBlock, env)
+		environment = &merged[0]
+	}
+
+	var currentDir *uint16
+	if dir != "" {
+		var err error
+		currentDir, err = syscall.UTF16PtrFromString(dir)
+		if err != nil {
+			return err
+		}
+	}
 	
 	// Setup startup info for user session
 	desktop, _ := syscall.UTF16PtrFromString("winsta0\\default")
@@ -223,8 +247,8 @@ func createProcessInSession(sessionId uint32, cmdLine *uint16) error {
 		0,
 		0,
 		CREATE_UNICODE_ENVIRONMENT|CREATE_NEW_CONSOLE,
-		envBlock,
-		0,
+		uintptr(unsafe.Pointer(environment)),
+		uintptr(unsafe.Pointer(currentDir)),
 		uintptr(unsafe.Pointer(&startupInfo)),
 		uintptr(unsafe.Pointer(&processInfo)),
 	)
@@ -244,4 +268,43 @@ func createProcessInSession(sessionId uint32, cmdLine *uint16) error {
 	log.Printf("Process handles closed, application should be running in session %d", sessionId)
 	
 	return nil
-}
\ No newline at end of file
+}
+
+// mergeEnvironment returns a copy of an environment block with env added.
+// Variables of the same name are replaced; names are case-insensitive.
+func mergeEnvironment(block *uint16, env map[string]string) []uint16 {
+	var vars []string
+	for ptr := unsafe.Pointer(block); ; {
+		var entry []uint16
+		for c := *(*uint16)(ptr); c != 0; c = *(*uint16)(ptr) {
+			entry = append(entry, c)
+			ptr = unsafe.Add(ptr, 2)
+		}
+		ptr = unsafe.Add(ptr, 2)
+		if len(entry) == 0 {
+			break
+		}
+		vars = append(vars, string(utf16.Decode(entry)))
+	}
+
+	for key, value := range env {
+		replaced := false
+		for i, v := range vars {
+			// Names may start with "=", like the "=C:" drive variables
+			if name, _, ok := strings.Cut(v[1:], "="); ok && strings.EqualFold(v[:1]+name, key) {
+				vars[i] = key + "=" + value
+				replaced = true
+			}
+		}
+		if !replaced {
+			vars = append(vars, key+"="+value)
+		}
+	}
+
+	var merged []uint16
+	for _, v := range vars {
+		merged = append(merged, utf16.Encode([]rune(v))...)
+		merged = append(merged, 0)
+	}
+	return append(merged, 0)
+}
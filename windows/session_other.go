@@ -3,7 +3,7 @@
 package windows
 
 // Dummy implementations for non-Windows platforms
-func ExecuteCommandInUserSession(command string, args []string) error {
+func ExecuteCommandInUserSession(command string, args []string, env map[string]string, dir string) error {
 	// Not supported on non-Windows platforms
 	return nil
 }
\ No newline at end of file